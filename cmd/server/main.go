@@ -1,13 +1,27 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"shopify-auth-app/internal/config"
 	"shopify-auth-app/internal/db"
+	"shopify-auth-app/internal/health"
 	"shopify-auth-app/internal/httpapi"
+	"shopify-auth-app/internal/metrics"
+	"shopify-auth-app/internal/ratelimit"
+	"shopify-auth-app/internal/redact"
 	"shopify-auth-app/internal/repository"
+	"shopify-auth-app/internal/shopify"
+	"shopify-auth-app/internal/telemetry"
+	"shopify-auth-app/internal/worker"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,28 +29,160 @@ import (
 func main() {
 	_ = godotenv.Load()
 
-	cfg := config.Load()
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	cfg, err := config.Load()
+	if err != nil {
+		// the logger is configured from cfg, so this goes straight to stderr. Secret values are never echoed
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// every log line passes through the redacting handler, whatever logged it
+	logger := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
-	}))
+	}), cfg.LogRedactKeys...))
+
+	if err := run(cfg, logger); err != nil {
+		logger.Error("server stopped with error", "err", err)
+		os.Exit(1)
+	}
+}
+
+// run wires the app and blocks until SIGINT/SIGTERM, then shuts down in order: stop accepting and
+// drain in-flight requests, stop background workers, close the pool, flush spans
+func run(cfg config.Config, logger *slog.Logger) error {
+	shutdownTracing, err := telemetry.Setup(context.Background(), telemetry.Options{
+		Exporter:     cfg.TracingExporter,
+		OTLPEndpoint: cfg.TracingOTLPEndpoint,
+		SampleRatio:  cfg.TracingSampleRatio,
+		ServiceName:  cfg.TracingServiceName,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// flush buffered spans before exiting
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
 
 	// db connection
-	pool, err := db.Connect(cfg.DatabaseURL)
+	pool, err := db.Connect(cfg.DatabaseURL, logger)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer pool.Close()
 
+	m := metrics.New()
+	m.Register(metrics.NewPoolCollector(pool))
+
 	shopRepo := repository.NewShopRepository(pool)
 	stateRepo := repository.NewStateRepository(pool)
+	sessionRepo := repository.NewSessionRepository(pool)
+
+	// rows from before multi-app support belong to the app served back then, the default app
+	defaultApp := cfg.DefaultApp()
+	if err := db.AdoptLegacyRows(context.Background(), pool, defaultApp.APIKey); err != nil {
+		return err
+	}
+
+	// one client serves every app: token exchanges pass the app's credentials explicitly
+	shopifyClient := shopify.NewClient(defaultApp.APIKey, defaultApp.APISecret,
+		shopify.WithTimeout(cfg.ShopifyHTTPTimeout),
+		shopify.WithUserAgent(cfg.ShopifyUserAgent),
+		shopify.WithRetries(cfg.ShopifyMaxRetries, 200*time.Millisecond, 2*time.Second),
+		shopify.WithLogger(logger),
+		shopify.WithRequestObserver(m.ObserveShopifyRequest),
+	)
+
+	// workers get their own context: they keep running while HTTP requests drain
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+
+	reconciler := worker.NewScopeReconciler(shopRepo, shopifyClient, cfg.ScopeReconcileInterval, logger)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		reconciler.Run(workerCtx)
+	}()
 
-	handlers := httpapi.NewHandlers(cfg, shopRepo, stateRepo, logger)
-	r := httpapi.NewRouter(handlers)
+	syncer := worker.NewShopSyncer(shopRepo, shopifyClient, logger)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		syncer.Run(workerCtx)
+	}()
 
-	addr := ":" + cfg.AppPort
+	ready := health.NewReadiness(cfg.ReadinessTimeout)
+	ready.Add("postgres", pool.Ping)
+	ready.Add("schema", db.CheckSchema(pool))
+	ready.Add("scope_reconciler", reconciler.Heartbeat().Check)
 
-	log.Printf("listening on %s", addr)
-	if err := r.Run(addr); err != nil {
-		log.Fatal(err)
+	// memory limits hold per replica only; postgres shares the buckets between replicas
+	var limiter ratelimit.Limiter
+	switch cfg.RateLimitBackend {
+	case "memory":
+		limiter = ratelimit.NewMemory()
+	case "postgres":
+		limiter = ratelimit.NewPostgres(pool)
 	}
+
+	handlers := httpapi.NewHandlers(cfg, shopRepo, stateRepo, sessionRepo, shopifyClient, syncer, m, ready, limiter, logger)
+	srv := &http.Server{
+		Addr:              ":" + cfg.AppPort,
+		Handler:           httpapi.NewRouter(handlers),
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		// the listener failed before any signal, e.g. the port is taken
+		stopWorkers()
+		workers.Wait()
+		return err
+	case <-signalCtx.Done():
+	}
+	// a second signal kills the process right away
+	stopSignals()
+
+	// fail /readyz first and keep serving until load balancers have seen it and stopped routing here
+	ready.Shutdown()
+	logger.Info("shutting down, failing readiness", "delay", cfg.ShutdownReadinessDelay)
+	time.Sleep(cfg.ShutdownReadinessDelay)
+
+	logger.Info("draining requests", "timeout", cfg.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDrain()
+
+	var shutdownErr error
+	if err := srv.Shutdown(drainCtx); err != nil {
+		// deadline hit: cut the remaining connections rather than hang
+		logger.Error("drain deadline exceeded, closing remaining connections", "err", err)
+		_ = srv.Close()
+		shutdownErr = err
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		shutdownErr = errors.Join(shutdownErr, err)
+	}
+
+	stopWorkers()
+	workers.Wait()
+	logger.Info("workers stopped")
+
+	pool.Close()
+	logger.Info("database pool closed")
+	return shutdownErr
 }
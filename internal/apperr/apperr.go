@@ -0,0 +1,141 @@
+// Package apperr defines the typed errors handlers return, their stable machine codes and
+// the single mapping from code to HTTP status and log level.
+package apperr
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Code is a stable, machine readable error identifier. Frontend and monitoring match on it, never on text
+type Code string
+
+const (
+	CodeMissingParameter    Code = "missing_parameter"
+	CodeInvalidShop         Code = "invalid_shop"
+	CodeInvalidHost         Code = "invalid_host"
+	CodeInvalidHMAC         Code = "invalid_hmac"
+	CodeStateExpired        Code = "state_expired"
+	CodeSessionMissing      Code = "session_missing"
+	CodeSessionInvalid      Code = "session_invalid"
+	CodeSessionShopMismatch Code = "session_shop_mismatch"
+	CodeInvalidSessionToken Code = "invalid_session_token"
+	CodeShopNotInstalled    Code = "shop_not_installed"
+	CodeShopFrozen          Code = "shop_frozen"
+	CodeShopNeedsReauth     Code = "shop_needs_reauth"
+	CodeInvalidShopState    Code = "invalid_shop_state"
+	CodeUnknownApp          Code = "unknown_app"
+	CodeForbidden           Code = "forbidden"
+	CodeRateLimited         Code = "rate_limited"
+	CodeInvalidWebhook      Code = "invalid_webhook_signature"
+	CodeInvalidPayload      Code = "invalid_payload"
+	CodeTokenExchange       Code = "token_exchange_failed"
+	CodeDatabase            Code = "database_error"
+	CodeInternal            Code = "internal_error"
+)
+
+type spec struct {
+	status int
+	level  slog.Level
+}
+
+// specs is the central table: which status a code maps to and how loudly it is logged.
+// Client mistakes stay at debug, signature failures are worth a warning, our own failures are errors
+var specs = map[Code]spec{
+	CodeMissingParameter:    {http.StatusBadRequest, slog.LevelDebug},
+	CodeInvalidShop:         {http.StatusBadRequest, slog.LevelDebug},
+	CodeInvalidHost:         {http.StatusBadRequest, slog.LevelWarn},
+	CodeInvalidHMAC:         {http.StatusUnauthorized, slog.LevelWarn},
+	CodeStateExpired:        {http.StatusUnauthorized, slog.LevelInfo},
+	CodeSessionMissing:      {http.StatusUnauthorized, slog.LevelDebug},
+	CodeSessionInvalid:      {http.StatusUnauthorized, slog.LevelInfo},
+	CodeSessionShopMismatch: {http.StatusUnauthorized, slog.LevelWarn},
+	CodeInvalidSessionToken: {http.StatusUnauthorized, slog.LevelInfo},
+	CodeShopNotInstalled:    {http.StatusNotFound, slog.LevelInfo},
+	CodeShopFrozen:          {http.StatusPaymentRequired, slog.LevelInfo},
+	CodeShopNeedsReauth:     {http.StatusUnauthorized, slog.LevelInfo},
+	CodeInvalidShopState:    {http.StatusConflict, slog.LevelWarn},
+	CodeUnknownApp:          {http.StatusNotFound, slog.LevelInfo},
+	CodeForbidden:           {http.StatusForbidden, slog.LevelWarn},
+	CodeRateLimited:         {http.StatusTooManyRequests, slog.LevelInfo},
+	CodeInvalidWebhook:      {http.StatusUnauthorized, slog.LevelWarn},
+	CodeInvalidPayload:      {http.StatusBadRequest, slog.LevelInfo},
+	CodeTokenExchange:       {http.StatusBadGateway, slog.LevelError},
+	CodeDatabase:            {http.StatusInternalServerError, slog.LevelError},
+	CodeInternal:            {http.StatusInternalServerError, slog.LevelError},
+}
+
+// Error carries a public Detail shown to the caller and an internal cause that is only logged
+type Error struct {
+	Code   Code
+	Detail string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Detail + ": " + e.Err.Error()
+	}
+	return string(e.Code) + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, detail string) *Error {
+	return &Error{Code: code, Detail: detail}
+}
+
+// Wrap attaches an internal cause. err is logged but never sent to the client
+func Wrap(code Code, err error, detail string) *Error {
+	return &Error{Code: code, Detail: detail, Err: err}
+}
+
+// From converts any error into an *Error, unknown errors become internal_error
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Wrap(CodeInternal, err, "internal error")
+}
+
+// Status returns the HTTP status for code, 500 for unknown codes
+func Status(code Code) int {
+	if s, ok := specs[code]; ok {
+		return s.status
+	}
+	return http.StatusInternalServerError
+}
+
+// LogLevel returns how a failure with code should be logged
+func LogLevel(code Code) slog.Level {
+	if s, ok := specs[code]; ok {
+		return s.level
+	}
+	return slog.LevelError
+}
+
+// Problem is an RFC 9457 application/problem+json body with our code and request id as extensions
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Problem(requestID string) Problem {
+	status := Status(e.Code)
+	return Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    e.Detail,
+		Code:      e.Code,
+		RequestID: requestID,
+	}
+}
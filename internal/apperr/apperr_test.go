@@ -0,0 +1,51 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	cause := errors.New("dial tcp: connection refused")
+	wrapped := fmt.Errorf("get shop: %w", Wrap(CodeDatabase, cause, "database error"))
+
+	e := From(wrapped)
+	if e.Code != CodeDatabase || !errors.Is(e, cause) {
+		t.Fatalf("unexpected error %+v", e)
+	}
+	if Status(e.Code) != http.StatusInternalServerError {
+		t.Fatalf("unexpected status %d", Status(e.Code))
+	}
+
+	unknown := From(errors.New("boom"))
+	if unknown.Code != CodeInternal || unknown.Detail != "internal error" {
+		t.Fatalf("unknown errors must become internal_error, got %+v", unknown)
+	}
+}
+
+func TestProblemHidesCause(t *testing.T) {
+	e := Wrap(CodeTokenExchange, errors.New("shopify returned status 400: secret stuff"), "failed to exchange token")
+	p := e.Problem("req-1")
+
+	if p.Status != http.StatusBadGateway || p.Code != CodeTokenExchange || p.RequestID != "req-1" {
+		t.Fatalf("unexpected problem %+v", p)
+	}
+	if p.Detail != "failed to exchange token" {
+		t.Fatalf("detail must not include the internal cause: %q", p.Detail)
+	}
+}
+
+func TestEveryCodeHasSpec(t *testing.T) {
+	for _, code := range []Code{
+		CodeMissingParameter, CodeInvalidShop, CodeInvalidHost, CodeInvalidHMAC, CodeStateExpired,
+		CodeSessionMissing, CodeSessionInvalid, CodeSessionShopMismatch, CodeInvalidSessionToken, CodeShopNotInstalled,
+		CodeShopFrozen, CodeShopNeedsReauth, CodeInvalidShopState, CodeUnknownApp, CodeForbidden,
+		CodeRateLimited, CodeInvalidWebhook, CodeInvalidPayload, CodeTokenExchange, CodeDatabase, CodeInternal,
+	} {
+		if _, ok := specs[code]; !ok {
+			t.Fatalf("code %q has no status mapping", code)
+		}
+	}
+}
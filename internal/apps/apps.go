@@ -0,0 +1,47 @@
+// Package apps is the registry of Shopify apps one deployment serves, looked up by name or API key.
+package apps
+
+import "shopify-auth-app/internal/config"
+
+// Registry is built once from the validated config and never changes, so it needs no locking
+type Registry struct {
+	list   []*config.App
+	byName map[string]*config.App
+	byKey  map[string]*config.App
+}
+
+func NewRegistry(apps []config.App) *Registry {
+	r := &Registry{byName: map[string]*config.App{}, byKey: map[string]*config.App{}}
+	for i := range apps {
+		app := &apps[i]
+		r.list = append(r.list, app)
+		r.byName[app.Name] = app
+		r.byKey[app.APIKey] = app
+	}
+	return r
+}
+
+// Default is the first configured app, served on the unprefixed routes. nil when none is configured
+func (r *Registry) Default() *config.App {
+	if len(r.list) == 0 {
+		return nil
+	}
+	return r.list[0]
+}
+
+// Lookup resolves ref as an app name first, then as an API key (client id)
+func (r *Registry) Lookup(ref string) (*config.App, bool) {
+	if app, ok := r.byName[ref]; ok {
+		return app, true
+	}
+	return r.ByAPIKey(ref)
+}
+
+func (r *Registry) ByAPIKey(apiKey string) (*config.App, bool) {
+	app, ok := r.byKey[apiKey]
+	return app, ok
+}
+
+func (r *Registry) All() []*config.App {
+	return r.list
+}
@@ -0,0 +1,32 @@
+package apps
+
+import (
+	"shopify-auth-app/internal/config"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry([]config.App{
+		{Name: "dev", APIKey: "dev-key"},
+		{Name: "partner", APIKey: "partner-key"},
+	})
+
+	if r.Default().Name != "dev" {
+		t.Fatalf("default must be the first app, got %q", r.Default().Name)
+	}
+	for ref, want := range map[string]string{"partner": "partner", "partner-key": "partner", "dev-key": "dev"} {
+		app, ok := r.Lookup(ref)
+		if !ok || app.Name != want {
+			t.Fatalf("Lookup(%q) = %v, %v; want %s", ref, app, ok, want)
+		}
+	}
+	if _, ok := r.Lookup("unknown"); ok {
+		t.Fatal("unknown app resolved")
+	}
+	if len(r.All()) != 2 {
+		t.Fatalf("unexpected apps %v", r.All())
+	}
+	if NewRegistry(nil).Default() != nil {
+		t.Fatal("empty registry must have no default")
+	}
+}
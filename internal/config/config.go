@@ -1,51 +1,151 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
+	"shopify-auth-app/internal/ratelimit"
+	"time"
 )
 
+// SessionKey is one cookie encryption key. The first configured key encrypts new cookies,
+// the others are only accepted when decrypting
+type SessionKey struct {
+	ID     string
+	Secret string
+}
+
+// DefaultAppName is the name of the app configured through the single-app SHOPIFY_* variables
+const DefaultAppName = "default"
+
+// App is one Shopify app served by this deployment. Its routes live under /apps/<Name>/,
+// the first configured app is also served on the unprefixed routes
+type App struct {
+	Name      string
+	APIKey    string
+	APISecret string
+	// APISecretSecondary is the previous secret while Shopify rotates it, empty otherwise. Signed
+	// requests are accepted under either; drop it once the secret_matches_total metric shows no more use
+	APISecretSecondary string
+	Scopes             string
+	CallbackURL        string
+	Embedded           bool
+
+	// legacy is set for the app read from SHOPIFY_API_KEY & co, validation messages name those variables
+	legacy bool
+}
+
 type Config struct {
-	AppPort          string
-	DatabaseURL      string
-	ShopifyAPIKey    string
-	ShopifyAPISecret string
-	ShopifyScopes    string
-	CallbackURL      string
-	SessionSecret    string
+	AppEnv      string
+	AppPort     string
+	DatabaseURL string
+	// Apps has at least one entry when loaded through Load
+	Apps        []App
+	SessionKeys []SessionKey
+
+	ScopeReconcileInterval time.Duration
+
+	HTTPReadHeaderTimeout time.Duration
+	HTTPReadTimeout       time.Duration
+	// HTTPWriteTimeout must cover the callback waiting on Shopify's token endpoint, retries included
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+	// ShutdownReadinessDelay is how long /readyz fails before draining starts, so load balancers notice
+	ShutdownReadinessDelay time.Duration
+	// ShutdownTimeout is the drain deadline, counted after ShutdownReadinessDelay
+	ShutdownTimeout  time.Duration
+	ReadinessTimeout time.Duration
+
+	ShopifyHTTPTimeout time.Duration
+	ShopifyMaxRetries  int
+	ShopifyUserAgent   string
+
+	LogRedactKeys []string
+
+	MetricsEnabled      bool
+	MetricsToken        string
+	MetricsAllowedCIDRs []netip.Prefix
+
+	TracingExporter     string
+	TracingOTLPEndpoint string
+	TracingSampleRatio  float64
+	TracingServiceName  string
+
+	// TrustedProxies may set X-Forwarded-For; the client IP of everyone else is the connection address
+	TrustedProxies []netip.Prefix
+
+	// RateLimitBackend is memory, postgres or off
+	RateLimitBackend string
+	RateLimitIP      ratelimit.Limit
+	RateLimitShop    ratelimit.Limit
 }
 
-func Load() Config {
-	shopifySecret := mustEnv("SHOPIFY_API_SECRET")
+// Load reads the configuration from environment variables layered over the optional CONFIG_FILE
+// (YAML or TOML). Any setting can also be read from the file named by <NAME>_FILE, for secrets mounted
+// by the orchestrator. Every problem found is reported in the returned error, not only the first
+func Load() (Config, error) {
+	l, err := newLoader(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{
+		AppEnv:      l.str("APP_ENV", "development"),
+		AppPort:     l.str("APP_PORT", "8080"),
+		DatabaseURL: l.required("DATABASE_URL"),
+		Apps:        l.apps(),
+		SessionKeys: l.sessionKeys("APP_SESSION_KEYS"),
+
+		ScopeReconcileInterval: l.duration("SCOPE_RECONCILE_INTERVAL", 6*time.Hour),
+
+		HTTPReadHeaderTimeout:  l.duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		HTTPReadTimeout:        l.duration("HTTP_READ_TIMEOUT", 15*time.Second),
+		HTTPWriteTimeout:       l.duration("HTTP_WRITE_TIMEOUT", 45*time.Second),
+		HTTPIdleTimeout:        l.duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownReadinessDelay: l.duration("SHUTDOWN_READINESS_DELAY", 5*time.Second),
+		ShutdownTimeout:        l.duration("SHUTDOWN_TIMEOUT", 20*time.Second),
+		ReadinessTimeout:       l.duration("READINESS_TIMEOUT", 2*time.Second),
 
-	sessionSecret := os.Getenv("APP_SESSION_SECRET")
-	if sessionSecret == "" {
-		sessionSecret = shopifySecret
+		ShopifyHTTPTimeout: l.duration("SHOPIFY_HTTP_TIMEOUT", 10*time.Second),
+		ShopifyMaxRetries:  l.int("SHOPIFY_MAX_RETRIES", 2),
+		ShopifyUserAgent:   l.str("SHOPIFY_USER_AGENT", "shopify-auth-app"),
+
+		LogRedactKeys: l.list("LOG_REDACT_KEYS"),
+
+		MetricsEnabled:      l.bool("METRICS_ENABLED", true),
+		MetricsToken:        l.str("METRICS_TOKEN", ""),
+		MetricsAllowedCIDRs: l.prefixList("METRICS_ALLOWED_CIDRS", "127.0.0.1/32,::1/128"),
+
+		TracingExporter:     l.choice("TRACING_EXPORTER", "none", "none", "stdout", "otlp"),
+		TracingOTLPEndpoint: l.str("TRACING_OTLP_ENDPOINT", ""),
+		TracingSampleRatio:  l.ratio("TRACING_SAMPLE_RATIO", 1),
+		TracingServiceName:  l.str("TRACING_SERVICE_NAME", "shopify-auth-app"),
+
+		TrustedProxies: l.prefixList("TRUSTED_PROXIES", "127.0.0.1/32,::1/128"),
+
+		RateLimitBackend: l.choice("RATE_LIMIT_BACKEND", "memory", "memory", "postgres", "off"),
+		RateLimitIP:      l.limit("RATE_LIMIT_IP", "30/1m"),
+		RateLimitShop:    l.limit("RATE_LIMIT_SHOP", "10/1m"),
 	}
+	l.unknownKeys()
+	l.errs = append(l.errs, cfg.validate()...)
 
-	return Config{
-		AppPort:          getEnv("APP_PORT", "8080"),
-		DatabaseURL:      mustEnv("DATABASE_URL"),
-		ShopifyAPIKey:    mustEnv("SHOPIFY_API_KEY"),
-		ShopifyAPISecret: shopifySecret,
-		ShopifyScopes:    getEnv("SHOPIFY_SCOPES", "read_products"),
-		CallbackURL:      mustEnv("OAUTH_CALLBACK_URL"),
-		SessionSecret:    sessionSecret,
+	if err := errors.Join(l.errs...); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", err)
 	}
+	return cfg, nil
 }
 
-func getEnv(key, fallback string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		return fallback
+// DefaultApp is the app served on the unprefixed routes
+func (c Config) DefaultApp() App {
+	if len(c.Apps) == 0 {
+		return App{}
 	}
-	return v
+	return c.Apps[0]
 }
 
-func mustEnv(key string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		log.Fatalf("missing env: %s", key)
-	}
-	return v
+// IsProduction reports whether the app runs behind HTTPS inside the Shopify Admin
+func (c Config) IsProduction() bool {
+	return c.AppEnv == "production"
 }
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// setEnv clears every setting the tests touch, then applies vars, so the outer environment cannot leak in
+func setEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for _, k := range []string{
+		"CONFIG_FILE", "APP_ENV", "APP_PORT", "DATABASE_URL", "SHOPIFY_API_KEY", "SHOPIFY_API_SECRET",
+		"SHOPIFY_API_SECRET_FILE", "SHOPIFY_API_SECRET_SECONDARY", "SHOPIFY_SCOPES", "OAUTH_CALLBACK_URL", "APP_SESSION_KEYS",
+		"APP_SESSION_KEYS_FILE", "SHOPIFY_MAX_RETRIES", "METRICS_ALLOWED_CIDRS", "HTTP_READ_TIMEOUT",
+		"SHOPIFY_APPS", "SHOPIFY_APP_DEV_API_KEY", "SHOPIFY_APP_DEV_API_SECRET", "SHOPIFY_APP_DEV_CALLBACK_URL",
+		"SHOPIFY_APP_PARTNER_EU_API_KEY", "SHOPIFY_APP_PARTNER_EU_API_SECRET", "SHOPIFY_APP_PARTNER_EU_CALLBACK_URL",
+		"SHOPIFY_APP_PARTNER_EU_EMBEDDED",
+	} {
+		t.Setenv(k, "")
+	}
+	for k, v := range vars {
+		t.Setenv(k, v)
+	}
+}
+
+func validEnv() map[string]string {
+	return map[string]string{
+		"DATABASE_URL":       "postgres://localhost/test",
+		"SHOPIFY_API_KEY":    "key",
+		"SHOPIFY_API_SECRET": testSecret,
+		"OAUTH_CALLBACK_URL": "https://app.example.com/auth/callback",
+		"APP_SESSION_KEYS":   "k1:" + testSecret,
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Env(t *testing.T) {
+	setEnv(t, validEnv())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	app := cfg.DefaultApp()
+	if cfg.AppPort != "8080" || len(cfg.Apps) != 1 || app.Name != DefaultAppName || app.Scopes != "read_products" || cfg.SessionKeys[0].ID != "k1" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	env := validEnv()
+	delete(env, "DATABASE_URL")
+	env["APP_PORT"] = "99999"
+	env["OAUTH_CALLBACK_URL"] = "http://app.example.com/auth/callback"
+	env["SHOPIFY_SCOPES"] = "read_products,products"
+	env["SHOPIFY_API_SECRET"] = "short"
+	env["APP_SESSION_KEYS"] = "k1:tiny"
+	env["SHOPIFY_MAX_RETRIES"] = "many"
+	setEnv(t, env)
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"DATABASE_URL is required",
+		`APP_PORT: invalid port "99999"`,
+		"OAUTH_CALLBACK_URL: must use https",
+		`SHOPIFY_SCOPES: malformed scope "products"`,
+		"SHOPIFY_API_SECRET: too short",
+		`APP_SESSION_KEYS: secret of key "k1" too short`,
+		`SHOPIFY_MAX_RETRIES: invalid non-negative integer "many"`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("error misses %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "tiny") {
+		t.Fatalf("error echoes a secret:\n%s", msg)
+	}
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	env := validEnv()
+	delete(env, "SHOPIFY_API_SECRET")
+	env["SHOPIFY_API_SECRET_FILE"] = writeFile(t, "secret", testSecret+"\n")
+	setEnv(t, env)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.DefaultApp().APISecret != testSecret {
+		t.Fatalf("secret file not trimmed: %q", cfg.DefaultApp().APISecret)
+	}
+
+	env["SHOPIFY_API_SECRET"] = testSecret
+	setEnv(t, env)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "SHOPIFY_API_SECRET and SHOPIFY_API_SECRET_FILE are both set") {
+		t.Fatalf("ambiguous secret not reported: %v", err)
+	}
+
+	delete(env, "SHOPIFY_API_SECRET")
+	env["SHOPIFY_API_SECRET_FILE"] = filepath.Join(t.TempDir(), "missing")
+	setEnv(t, env)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "SHOPIFY_API_SECRET_FILE") {
+		t.Fatalf("missing secret file not reported: %v", err)
+	}
+}
+
+func TestLoad_SecondarySecret(t *testing.T) {
+	env := validEnv()
+	env["SHOPIFY_API_SECRET_SECONDARY"] = strings.Repeat("o", minSecretLen)
+	setEnv(t, env)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.DefaultApp().APISecretSecondary != env["SHOPIFY_API_SECRET_SECONDARY"] {
+		t.Fatalf("secondary secret not loaded: %+v", cfg.DefaultApp())
+	}
+
+	env["SHOPIFY_API_SECRET_SECONDARY"] = testSecret
+	setEnv(t, env)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "SHOPIFY_API_SECRET_SECONDARY: same as SHOPIFY_API_SECRET") {
+		t.Fatalf("reused secret not reported: %v", err)
+	}
+}
+
+func TestLoad_YAMLFileUnderEnv(t *testing.T) {
+	secretPath := writeFile(t, "session_keys", "k1:"+testSecret)
+	path := writeFile(t, "config.yaml", `
+app_port: 9090
+database_url: postgres://file/db
+oauth_callback_url: https://file.example.com/auth/callback
+app_session_keys_file: `+secretPath+`
+shopify:
+  api_key: file-key
+  api_secret: `+testSecret+`
+  scopes: [read_products, write_orders]
+metrics:
+  allowed_cidrs:
+    - 10.0.0.0/8
+http:
+  read_timeout: 30s
+`)
+	setEnv(t, map[string]string{"CONFIG_FILE": path, "APP_PORT": "7070"})
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AppPort != "7070" {
+		t.Fatalf("env must win over the file, got port %q", cfg.AppPort)
+	}
+	if app := cfg.DefaultApp(); app.APIKey != "file-key" || app.Scopes != "read_products,write_orders" || cfg.HTTPReadTimeout != 30*time.Second {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if len(cfg.SessionKeys) != 1 || cfg.SessionKeys[0].Secret != testSecret {
+		t.Fatalf("_FILE key in config file not read: %+v", cfg.SessionKeys)
+	}
+	if len(cfg.MetricsAllowedCIDRs) != 1 || cfg.MetricsAllowedCIDRs[0].String() != "10.0.0.0/8" {
+		t.Fatalf("list not applied: %v", cfg.MetricsAllowedCIDRs)
+	}
+}
+
+func TestLoad_TOMLFile(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+database_url = "postgres://file/db"
+oauth_callback_url = "https://file.example.com/auth/callback"
+app_session_keys = ["k1:`+testSecret+`", "k0:`+testSecret+`"]
+shopify_max_retries = 5
+
+[shopify]
+api_key = "file-key"
+api_secret = "`+testSecret+`"
+apii_key = "typo"
+`)
+	setEnv(t, map[string]string{"CONFIG_FILE": path})
+
+	_, err := Load()
+	if err == nil || !strings.Contains(err.Error(), "unknown config file key SHOPIFY_APII_KEY") {
+		t.Fatalf("typo not reported: %v", err)
+	}
+
+	path = writeFile(t, "config.toml", strings.ReplaceAll(readFile(t, path), `apii_key = "typo"`, ""))
+	setEnv(t, map[string]string{"CONFIG_FILE": path})
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.ShopifyMaxRetries != 5 || len(cfg.SessionKeys) != 2 || cfg.SessionKeys[1].ID != "k0" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestLoad_UnsupportedFile(t *testing.T) {
+	setEnv(t, map[string]string{"CONFIG_FILE": writeFile(t, "config.json", "{}")})
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "unsupported extension") {
+		t.Fatalf("expected unsupported extension error, got %v", err)
+	}
+}
+
+func TestLoad_MultipleApps(t *testing.T) {
+	env := validEnv()
+	delete(env, "SHOPIFY_API_KEY")
+	delete(env, "SHOPIFY_API_SECRET")
+	delete(env, "OAUTH_CALLBACK_URL")
+	env["SHOPIFY_APPS"] = "dev,partner-eu"
+	env["SHOPIFY_SCOPES"] = "read_products,write_products"
+	env["SHOPIFY_APP_DEV_API_KEY"] = "dev-key"
+	env["SHOPIFY_APP_DEV_API_SECRET"] = testSecret
+	env["SHOPIFY_APP_DEV_CALLBACK_URL"] = "https://app.example.com/auth/callback"
+	env["SHOPIFY_APP_PARTNER_EU_API_KEY"] = "partner-key"
+	env["SHOPIFY_APP_PARTNER_EU_API_SECRET"] = testSecret
+	env["SHOPIFY_APP_PARTNER_EU_CALLBACK_URL"] = "https://app.example.com/apps/partner-eu/auth/callback"
+	env["SHOPIFY_APP_PARTNER_EU_EMBEDDED"] = "true"
+	setEnv(t, env)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Apps) != 2 || cfg.DefaultApp().Name != "dev" {
+		t.Fatalf("unexpected apps %+v", cfg.Apps)
+	}
+	partner := cfg.Apps[1]
+	if partner.APIKey != "partner-key" || !partner.Embedded || partner.Scopes != "read_products,write_products" {
+		t.Fatalf("unexpected app %+v", partner)
+	}
+
+	// only the default app may use the unprefixed callback, and api keys must be unique
+	env["SHOPIFY_APP_PARTNER_EU_CALLBACK_URL"] = "https://app.example.com/auth/callback"
+	env["SHOPIFY_APP_PARTNER_EU_API_KEY"] = "dev-key"
+	delete(env, "SHOPIFY_APP_DEV_API_SECRET")
+	setEnv(t, env)
+	_, err = Load()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"SHOPIFY_APP_DEV_API_SECRET is required",
+		"SHOPIFY_APP_PARTNER_EU_CALLBACK_URL: path must be /apps/partner-eu/auth/callback",
+		"SHOPIFY_APP_PARTNER_EU_API_KEY: api key used by two apps",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error misses %q:\n%v", want, err)
+		}
+	}
+}
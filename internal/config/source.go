@@ -0,0 +1,321 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"shopify-auth-app/internal/ratelimit"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// loader resolves each setting from, in order: the environment variable, a file named by <NAME>_FILE,
+// the config file, and finally the default. Problems are collected instead of stopping at the first one
+type loader struct {
+	// file holds the config file flattened to env style names: shopify.api_key becomes SHOPIFY_API_KEY
+	file map[string]string
+	used map[string]bool
+	errs []error
+}
+
+func newLoader(path string) (*loader, error) {
+	l := &loader{file: map[string]string{}, used: map[string]bool{}}
+	if path == "" {
+		return l, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &doc)
+	case ".toml":
+		err = toml.Unmarshal(raw, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q, want .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	flatten(l.file, "", doc)
+	return l, nil
+}
+
+// flatten writes nested tables as NAME_SUBNAME keys and lists as comma separated values
+func flatten(out map[string]string, prefix string, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, sub := range v {
+			flatten(out, join(prefix, k), sub)
+		}
+	case map[any]any:
+		for k, sub := range v {
+			flatten(out, join(prefix, fmt.Sprint(k)), sub)
+		}
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		out[prefix] = ""
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+func join(prefix, key string) string {
+	key = strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+func (l *loader) errorf(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+// lookup returns the raw value of key, "" when it is not set anywhere
+func (l *loader) lookup(key string) string {
+	l.used[key], l.used[key+"_FILE"] = true, true
+
+	if v := os.Getenv(key); v != "" {
+		if os.Getenv(key+"_FILE") != "" {
+			l.errorf("%s and %s_FILE are both set", key, key)
+		}
+		return v
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return l.readSecret(key, path)
+	}
+	if v := l.file[key]; v != "" {
+		return v
+	}
+	if path := l.file[key+"_FILE"]; path != "" {
+		return l.readSecret(key, path)
+	}
+	return ""
+}
+
+// readSecret reads a mounted secret file. Only the trailing newline most editors add is trimmed
+func (l *loader) readSecret(key, path string) string {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		l.errorf("%s_FILE: %v", key, err)
+		return ""
+	}
+	return strings.TrimRight(string(raw), "\r\n")
+}
+
+// unknownKeys reports config file entries no setting asked for, usually typos
+func (l *loader) unknownKeys() {
+	var unknown []string
+	for k := range l.file {
+		if !l.used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		l.errorf("unknown config file key %s", k)
+	}
+}
+
+func (l *loader) str(key, fallback string) string {
+	if v := l.lookup(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (l *loader) required(key string) string {
+	v := l.lookup(key)
+	if v == "" {
+		l.errorf("%s is required", key)
+	}
+	return v
+}
+
+func (l *loader) duration(key string, fallback time.Duration) time.Duration {
+	v := l.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		l.errorf("%s: invalid duration %q", key, v)
+		return fallback
+	}
+	return d
+}
+
+func (l *loader) bool(key string, fallback bool) bool {
+	v := l.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.errorf("%s: invalid boolean %q", key, v)
+		return fallback
+	}
+	return b
+}
+
+func (l *loader) int(key string, fallback int) int {
+	v := l.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		l.errorf("%s: invalid non-negative integer %q", key, v)
+		return fallback
+	}
+	return n
+}
+
+func (l *loader) ratio(key string, fallback float64) float64 {
+	v := l.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		l.errorf("%s: invalid ratio %q, want 0..1", key, v)
+		return fallback
+	}
+	return f
+}
+
+// list reads a comma separated list, dropping empty entries
+func (l *loader) list(key string) []string {
+	var out []string
+	for _, v := range strings.Split(l.lookup(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// prefixList reads a comma separated list of CIDRs, a bare IP counts as a single address
+func (l *loader) prefixList(key, fallback string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, v := range strings.Split(l.str(key, fallback), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				l.errorf("%s: invalid CIDR %q", key, v)
+				continue
+			}
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			l.errorf("%s: invalid CIDR %q", key, v)
+			continue
+		}
+		out = append(out, p.Masked())
+	}
+	return out
+}
+
+// choice reads a value that must be one of allowed
+func (l *loader) choice(key, fallback string, allowed ...string) string {
+	v := l.str(key, fallback)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	l.errorf("%s: invalid value %q, want one of %s", key, v, strings.Join(allowed, ", "))
+	return fallback
+}
+
+// limit reads a rate limit such as "30/1m"
+func (l *loader) limit(key, fallback string) ratelimit.Limit {
+	lim, err := ratelimit.ParseLimit(l.str(key, fallback))
+	if err != nil {
+		l.errorf("%s: %v", key, err)
+		lim, _ = ratelimit.ParseLimit(fallback)
+	}
+	return lim
+}
+
+// apps reads SHOPIFY_APPS, a list of app names each configured through SHOPIFY_APP_<NAME>_*.
+// Without it the single app of SHOPIFY_API_KEY, SHOPIFY_API_SECRET and OAUTH_CALLBACK_URL is served
+func (l *loader) apps() []App {
+	names := l.list("SHOPIFY_APPS")
+	scopes := l.str("SHOPIFY_SCOPES", "read_products")
+	if len(names) == 0 {
+		return []App{{
+			Name:               DefaultAppName,
+			APIKey:             l.required("SHOPIFY_API_KEY"),
+			APISecret:          l.required("SHOPIFY_API_SECRET"),
+			APISecretSecondary: l.str("SHOPIFY_API_SECRET_SECONDARY", ""),
+			Scopes:             scopes,
+			CallbackURL:        l.required("OAUTH_CALLBACK_URL"),
+			Embedded:           l.bool("SHOPIFY_EMBEDDED", false),
+			legacy:             true,
+		}}
+	}
+
+	out := make([]App, 0, len(names))
+	for _, name := range names {
+		prefix := appEnvPrefix(name)
+		out = append(out, App{
+			Name:               name,
+			APIKey:             l.required(prefix + "API_KEY"),
+			APISecret:          l.required(prefix + "API_SECRET"),
+			APISecretSecondary: l.str(prefix+"API_SECRET_SECONDARY", ""),
+			Scopes:             l.str(prefix+"SCOPES", scopes),
+			CallbackURL:        l.required(prefix + "CALLBACK_URL"),
+			Embedded:           l.bool(prefix+"EMBEDDED", false),
+		})
+	}
+	return out
+}
+
+// appEnvPrefix is the variable prefix of a named app: partner-eu reads SHOPIFY_APP_PARTNER_EU_*
+func appEnvPrefix(name string) string {
+	return "SHOPIFY_APP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+// sessionKeys reads "id:secret,id:secret", the first entry being the active key
+func (l *loader) sessionKeys(key string) []SessionKey {
+	var keys []SessionKey
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(l.required(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" || secret == "" || strings.Contains(id, ".") || seen[id] {
+			// never echo the entry, it contains the secret
+			l.errorf("%s: invalid entry with id %q, expected unique id:secret", key, id)
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, SessionKey{ID: id, Secret: secret})
+	}
+	return keys
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// minSecretLen is the shortest accepted secret; Shopify API secrets are 32 characters
+const minSecretLen = 32
+
+// appNameRe restricts app names to what can appear in a URL path and an env variable name
+var appNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// scopeRe matches one Shopify access scope such as read_products or unauthenticated_read_product_listings
+var scopeRe = regexp.MustCompile(`^(unauthenticated_|customer_)?(read|write)_[a-z][a-z_]*$`)
+
+// validate checks values that parsed but make no sense together. Settings that failed to load were
+// already reported and are skipped here
+func (c Config) validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.AppPort); err != nil || port < 1 || port > 65535 {
+		add("APP_PORT: invalid port %q", c.AppPort)
+	}
+	if c.AppEnv != "development" && c.AppEnv != "production" {
+		add("APP_ENV: invalid value %q, want development or production", c.AppEnv)
+	}
+
+	if len(c.Apps) == 0 {
+		add("no Shopify app configured")
+	}
+	names, keys := map[string]bool{}, map[string]bool{}
+	for i, app := range c.Apps {
+		if !appNameRe.MatchString(app.Name) || names[app.Name] {
+			add("SHOPIFY_APPS: invalid or duplicate app name %q", app.Name)
+		}
+		names[app.Name] = true
+		if app.APIKey != "" && keys[app.APIKey] {
+			add("%s: api key used by two apps", app.envName("API_KEY"))
+		}
+		keys[app.APIKey] = true
+		errs = append(errs, app.validate(i == 0)...)
+	}
+
+	for _, k := range c.SessionKeys {
+		if len(k.Secret) < minSecretLen {
+			add("APP_SESSION_KEYS: secret of key %q too short, want at least %d characters", k.ID, minSecretLen)
+		}
+	}
+	return errs
+}
+
+// validate checks one app. Shopify redirects to CallbackURL, and the route it hits must resolve back
+// to the same app: /apps/<name>/auth/callback, or /auth/callback for the default app
+func (a App) validate(isDefault bool) []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if a.CallbackURL != "" {
+		u, err := url.Parse(a.CallbackURL)
+		switch {
+		case err != nil || u.Host == "":
+			add("%s: invalid url %q", a.envName("CALLBACK_URL"), a.CallbackURL)
+		case u.Scheme != "https":
+			// Shopify only redirects to HTTPS, use a tunnel such as ngrok in development
+			add("%s: must use https, got %q", a.envName("CALLBACK_URL"), a.CallbackURL)
+		case u.Path != "/apps/"+a.Name+"/auth/callback" && !(isDefault && u.Path == "/auth/callback"):
+			add("%s: path must be /apps/%s/auth/callback, got %q", a.envName("CALLBACK_URL"), a.Name, u.Path)
+		}
+	}
+
+	for _, scope := range strings.Split(a.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); !scopeRe.MatchString(scope) {
+			add("%s: malformed scope %q", a.envName("SCOPES"), scope)
+		}
+	}
+
+	if a.APISecret != "" && len(a.APISecret) < minSecretLen {
+		add("%s: too short, want at least %d characters", a.envName("API_SECRET"), minSecretLen)
+	}
+	if a.APISecretSecondary != "" && len(a.APISecretSecondary) < minSecretLen {
+		add("%s: too short, want at least %d characters", a.envName("API_SECRET_SECONDARY"), minSecretLen)
+	}
+	if a.APISecretSecondary != "" && a.APISecretSecondary == a.APISecret {
+		add("%s: same as %s", a.envName("API_SECRET_SECONDARY"), a.envName("API_SECRET"))
+	}
+	return errs
+}
+
+// envName is the variable a setting of the app was read from, for error messages
+func (a App) envName(field string) string {
+	if a.legacy {
+		if field == "CALLBACK_URL" {
+			return "OAUTH_CALLBACK_URL"
+		}
+		return "SHOPIFY_" + field
+	}
+	return appEnvPrefix(a.Name) + field
+}
@@ -2,17 +2,20 @@ package db
 
 import (
 	"context"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func Connect(databaseURL string) (*pgxpool.Pool, error) {
+// Connect opens the pool. Queries are logged through logger, or through the request logger in their context
+func Connect(databaseURL string, logger *slog.Logger) (*pgxpool.Pool, error) {
 	cfg, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, err
 	}
 	cfg.MaxConns = 5
+	cfg.ConnConfig.Tracer = queryLogger{log: logger}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SchemaVersion is the newest migration this binary needs. Bump it together with every new file in migrations/
+const SchemaVersion = 10
+
+// CheckSchema fails when the database has not been migrated to SchemaVersion yet
+func CheckSchema(pool *pgxpool.Pool) func(context.Context) error {
+	return func(ctx context.Context) error {
+		const q = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`
+
+		var version int
+		if err := pool.QueryRow(ctx, q).Scan(&version); err != nil {
+			return err
+		}
+		if version < SchemaVersion {
+			return fmt.Errorf("schema version %d, need %d", version, SchemaVersion)
+		}
+		return nil
+	}
+}
+
+// AdoptLegacyRows assigns rows written before multi-app support (an empty api_key) to apiKey, the app the
+// deployment served back then. It runs on every start and does nothing once no such row is left
+func AdoptLegacyRows(ctx context.Context, pool *pgxpool.Pool, apiKey string) error {
+	for _, q := range []string{
+		`UPDATE shops SET api_key = $1 WHERE api_key = '';`,
+		`UPDATE oauth_states SET api_key = $1 WHERE api_key = '';`,
+		`UPDATE sessions SET api_key = $1 WHERE api_key = '';`,
+	} {
+		if _, err := pool.Exec(ctx, q, apiKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"shopify-auth-app/internal/logctx"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	sql   string
+	start time.Time
+}
+
+// queryLogger logs every query with the logger of the calling request, so repository calls share
+// its request id. Arguments are never logged, they contain access tokens
+type queryLogger struct {
+	log *slog.Logger
+}
+
+func (t queryLogger) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{sql: data.SQL, start: time.Now()})
+}
+
+func (t queryLogger) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	qs, _ := ctx.Value(queryStartKey{}).(queryStart)
+	log := logctx.From(ctx, t.log)
+	args := []any{
+		"sql", compactSQL(qs.sql),
+		"duration", time.Since(qs.start),
+		"rows", data.CommandTag.RowsAffected(),
+	}
+
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		log.Warn("db query failed", append(args, "err", data.Err)...)
+		return
+	}
+	log.Debug("db query", args...)
+}
+
+// compactSQL folds the multi-line query constants into one line
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
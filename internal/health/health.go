@@ -0,0 +1,145 @@
+// Package health runs the readiness checks behind /readyz and tracks background worker heartbeats.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckFunc reports a dependency as healthy by returning nil
+type CheckFunc func(ctx context.Context) error
+
+// CheckResult is public in /readyz through its status only: errors can carry hosts and DSN details,
+// they and the latency are for the server log
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"-"`
+	Error     string  `json:"-"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// OK reports whether every check passed
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+var errShuttingDown = errors.New("shutting down")
+
+// Readiness runs its checks concurrently, each bounded by timeout
+type Readiness struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]CheckFunc
+
+	shuttingDown atomic.Bool
+}
+
+func NewReadiness(timeout time.Duration) *Readiness {
+	return &Readiness{timeout: timeout, checks: map[string]CheckFunc{}}
+}
+
+// Add registers a named check, a later Add with the same name replaces it
+func (r *Readiness) Add(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = check
+}
+
+// Shutdown makes every following report fail so load balancers stop routing before the server drains
+func (r *Readiness) Shutdown() {
+	r.shuttingDown.Store(true)
+}
+
+func (r *Readiness) Check(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: map[string]CheckResult{}}
+	if r.shuttingDown.Load() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: errShuttingDown.Error()}
+		return report
+	}
+
+	r.mu.RLock()
+	names := append([]string(nil), r.names...)
+	checks := make([]CheckFunc, len(names))
+	for i, n := range names {
+		checks[i] = r.checks[n]
+	}
+	r.mu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.run(ctx, checks[i])
+		}()
+	}
+	wg.Wait()
+
+	for i, n := range names {
+		report.Checks[n] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (r *Readiness) run(ctx context.Context, check CheckFunc) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	res := CheckResult{Status: StatusOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Heartbeat is beaten by a background worker on every loop; it fails once no beat arrived within maxAge
+type Heartbeat struct {
+	maxAge time.Duration
+	now    func() time.Time
+	last   atomic.Int64
+}
+
+func NewHeartbeat(maxAge time.Duration) *Heartbeat {
+	return &Heartbeat{maxAge: maxAge, now: time.Now}
+}
+
+func (h *Heartbeat) Beat() {
+	h.last.Store(h.now().UnixNano())
+}
+
+// Check matches CheckFunc
+func (h *Heartbeat) Check(context.Context) error {
+	last := h.last.Load()
+	if last == 0 {
+		return errors.New("no heartbeat yet")
+	}
+	if age := h.now().Sub(time.Unix(0, last)); age > h.maxAge {
+		return errors.New("last heartbeat " + age.Round(time.Second).String() + " ago")
+	}
+	return nil
+}
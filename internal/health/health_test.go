@@ -0,0 +1,61 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadinessCheck(t *testing.T) {
+	r := NewReadiness(20 * time.Millisecond)
+	r.Add("db", func(ctx context.Context) error { return nil })
+	r.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := r.Check(context.Background())
+	if report.OK() {
+		t.Fatalf("a timed out check must fail readiness: %+v", report)
+	}
+	if report.Checks["db"].Status != StatusOK || report.Checks["slow"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("unexpected checks %+v", report.Checks)
+	}
+	if report.Checks["slow"].LatencyMS < 20 {
+		t.Fatalf("latency not measured: %+v", report.Checks["slow"])
+	}
+
+	r.Add("slow", func(ctx context.Context) error { return nil })
+	if report := r.Check(context.Background()); !report.OK() || len(report.Checks) != 2 {
+		t.Fatalf("replaced check should pass: %+v", report)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	hb := NewHeartbeat(time.Minute)
+	hb.now = func() time.Time { return now }
+
+	if err := hb.Check(context.Background()); err == nil {
+		t.Fatalf("no beat yet must fail")
+	}
+	hb.Beat()
+	now = now.Add(59 * time.Second)
+	if err := hb.Check(context.Background()); err != nil {
+		t.Fatalf("fresh beat failed: %v", err)
+	}
+	now = now.Add(2 * time.Second)
+	if err := hb.Check(context.Background()); err == nil {
+		t.Fatalf("stale beat must fail")
+	}
+}
+
+func TestShutdownFailsReadiness(t *testing.T) {
+	r := NewReadiness(time.Second)
+	r.Add("db", func(ctx context.Context) error { return errors.New("unused") })
+	r.Shutdown()
+	if report := r.Check(context.Background()); report.OK() || report.Checks["shutdown"].Status != StatusFail {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/repository"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiShopKey holds the shop proven by the session token of an /api request
+const apiShopKey = "api_shop"
+
+// SessionTokenAuth guards the JSON API called by the embedded frontend: every request carries an
+// App Bridge session token signed with one of the app's secrets
+func (h *Handlers) SessionTokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tok, err := h.verifySessionToken(c, h.app(c))
+		if err != nil {
+			h.fail(c, apperr.Wrap(apperr.CodeInvalidSessionToken, err, "invalid session token"))
+			return
+		}
+		shop, ok := normalizeAndValidateShop(tok.Shop())
+		if !ok {
+			h.fail(c, apperr.New(apperr.CodeInvalidShop, "invalid shop"))
+			return
+		}
+		c.Set(apiShopKey, shop)
+		c.Next()
+	}
+}
+
+// APIShop returns the installation of the session token's shop
+func (h *Handlers) APIShop(c *gin.Context) {
+	app := h.app(c)
+	shop := c.GetString(apiShopKey)
+
+	s, err := h.shopRepo.GetByDomain(c.Request.Context(), app.APIKey, shop)
+	if err == repository.ErrNotFound {
+		h.fail(c, apperr.New(apperr.CodeShopNotInstalled, "shop not installed"), "shop", shop)
+		return
+	}
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+	if err := shopStateError(s); err != nil {
+		h.fail(c, err, "shop", shop, "shop_state", s.State)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shop":               s.ShopDomain,
+		"state":              s.State,
+		"scopes":             s.ScopeList(),
+		"first_installed_at": s.FirstInstalledAt,
+		"installed_at":       s.InstalledAt,
+		"reinstall_count":    s.ReinstallCount,
+		"updated_at":         s.UpdatedAt,
+	})
+}
+
+// defaultTimeline is the page size of APIShopEvents without ?limit
+const defaultTimeline = 50
+
+// APIShopEvents returns the history of the session token's installation, newest first. ?limit sets the
+// page size, ?before=<next_before> of the previous page continues with older events
+func (h *Handlers) APIShopEvents(c *gin.Context) {
+	app := h.app(c)
+	shop := c.GetString(apiShopKey)
+
+	limit, err := queryInt(c, "limit", defaultTimeline)
+	if err != nil || limit < 1 || limit > repository.MaxTimeline {
+		h.fail(c, apperr.New(apperr.CodeInvalidPayload, fmt.Sprintf("limit must be between 1 and %d", repository.MaxTimeline)))
+		return
+	}
+	before, err := queryInt(c, "before", 0)
+	if err != nil || before < 0 {
+		h.fail(c, apperr.New(apperr.CodeInvalidPayload, "invalid before"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.shopRepo.GetByDomain(ctx, app.APIKey, shop); err == repository.ErrNotFound {
+		h.fail(c, apperr.New(apperr.CodeShopNotInstalled, "shop not installed"), "shop", shop)
+		return
+	} else if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+
+	events, err := h.shopRepo.Timeline(ctx, app.APIKey, shop, int64(before), limit)
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+
+	out := make([]gin.H, 0, len(events))
+	for _, e := range events {
+		out = append(out, gin.H{
+			"id":         e.ID,
+			"type":       e.Type,
+			"actor":      e.Actor,
+			"ip":         e.IP,
+			"metadata":   e.Metadata,
+			"created_at": e.CreatedAt,
+		})
+	}
+	resp := gin.H{"events": out}
+	if len(events) == limit {
+		resp["next_before"] = events[len(events)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(c *gin.Context, name string, fallback int) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(v)
+}
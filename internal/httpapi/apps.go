@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/config"
+	"shopify-auth-app/internal/shopify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appKey holds the *config.App a request belongs to
+const appKey = "app"
+
+// resolveApp returns the app of the route: the :app path segment, a name or an API key, or the default
+// app on the unprefixed routes. The result is cached on the context for later middleware and handlers
+func (h *Handlers) resolveApp(c *gin.Context) (*config.App, bool) {
+	if v, ok := c.Get(appKey); ok {
+		return v.(*config.App), true
+	}
+
+	var (
+		app *config.App
+		ok  bool
+	)
+	if ref := c.Param("app"); ref != "" {
+		app, ok = h.apps.Lookup(ref)
+	} else {
+		app = h.apps.Default()
+		ok = app != nil
+	}
+	if ok {
+		c.Set(appKey, app)
+	}
+	return app, ok
+}
+
+// ResolveApp rejects requests for apps this deployment does not serve
+func (h *Handlers) ResolveApp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := h.resolveApp(c); !ok {
+			h.fail(c, apperr.New(apperr.CodeUnknownApp, "unknown app"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// app is the app of a request that went through ResolveApp
+func (h *Handlers) app(c *gin.Context) *config.App {
+	app, _ := h.resolveApp(c)
+	return app
+}
+
+// appPath keeps redirects on the routes the request came in on: /apps/<name><path> or the unprefixed path
+func appPath(c *gin.Context, app *config.App, path string) string {
+	if c.Param("app") == "" {
+		return path
+	}
+	return "/apps/" + app.Name + path
+}
+
+// cookieName is app_session for the default app and app_session_<name> for the others,
+// so apps opened side by side in one browser keep separate sessions
+func (h *Handlers) cookieName(app *config.App) string {
+	if app == h.apps.Default() {
+		return sessionCookieName
+	}
+	return sessionCookieName + "_" + app.Name
+}
+
+func credentials(app *config.App) shopify.Credentials {
+	return shopify.Credentials{APIKey: app.APIKey, APISecret: app.APISecret}
+}
+
+// secrets are the app's current and, during a rotation, previous client secret
+func secrets(app *config.App) shopify.Secrets {
+	return shopify.Secrets{Primary: app.APISecret, Secondary: app.APISecretSecondary}
+}
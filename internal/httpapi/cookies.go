@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+	"shopify-auth-app/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CookiePolicy decides the attributes of every cookie the app sets.
+// Inside the Shopify Admin iframe the app runs in a third-party context, where browsers only
+// keep cookies that are Secure, SameSite=None and (with CHIPS) Partitioned
+type CookiePolicy struct {
+	Secure      bool
+	SameSite    http.SameSite
+	Partitioned bool
+	Path        string
+}
+
+// NewCookiePolicy returns the embedded-app policy in production and the plain HTTP one for local development
+func NewCookiePolicy(cfg config.Config) CookiePolicy {
+	if cfg.IsProduction() {
+		return CookiePolicy{
+			Secure:      true,
+			SameSite:    http.SameSiteNoneMode,
+			Partitioned: true,
+			Path:        "/",
+		}
+	}
+	return CookiePolicy{
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	}
+}
+
+func (p CookiePolicy) set(c *gin.Context, name, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:        name,
+		Value:       value,
+		Path:        p.Path,
+		MaxAge:      maxAge,
+		Secure:      p.Secure,
+		HttpOnly:    true,
+		SameSite:    p.SameSite,
+		Partitioned: p.Partitioned,
+	})
+}
+
+func (p CookiePolicy) clear(c *gin.Context, name string) {
+	p.set(c, name, "", -1)
+}
@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// renderExitIframe renders a page that reloads /login for shop at the top level, where the OAuth redirect is allowed.
+// rawHost is passed along so the top-level request can store it with the OAuth state
+func (h *Handlers) renderExitIframe(c *gin.Context, app *config.App, shop, rawHost string) {
+	q := url.Values{"shop": {shop}}
+	if rawHost != "" {
+		q.Set("host", rawHost)
+	}
+	target, err := appURL(app, appPath(c, app, "/login"), q)
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeInternal, err, "failed to build redirect url"), "shop", shop)
+		return
+	}
+
+	// App Bridge intercepts window.open(..., "_top") from inside the Admin
+	h.renderHTML(c, http.StatusOK, "exit_iframe.html", gin.H{
+		"APIKey":      app.APIKey,
+		"RedirectURL": target,
+	})
+}
+
+// appURL builds an absolute URL on our own origin, taken from the app's OAuth callback URL
+func appURL(app *config.App, path string, q url.Values) (string, error) {
+	base, err := url.Parse(app.CallbackURL)
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{
+		Scheme:   base.Scheme,
+		Host:     base.Host,
+		Path:     path,
+		RawQuery: q.Encode(),
+	}
+	return u.String(), nil
+}
+
+// postInstallRedirect is where the merchant lands after OAuth: back inside the admin the flow started from
+// for embedded apps, the standalone dashboard otherwise. host was validated by shopify.ParseHost in Login
+func (h *Handlers) postInstallRedirect(c *gin.Context, app *config.App, shop, host string) string {
+	if app.Embedded {
+		if host != "" {
+			return "https://" + host + "/apps/" + url.PathEscape(app.APIKey)
+		}
+		return "https://" + shop + "/admin/apps/" + url.PathEscape(app.APIKey)
+	}
+	return appPath(c, app, "/dashboard") + "?shop=" + url.QueryEscape(shop)
+}
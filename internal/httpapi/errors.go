@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"shopify-auth-app/internal/apperr"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fail is the single exit for every failed request: it logs err according to its code and writes
+// an HTML error page for browsers or an application/problem+json body for everything else.
+// attrs are extra log attributes, they are never sent to the client
+func (h *Handlers) fail(c *gin.Context, err error, attrs ...any) {
+	e := apperr.From(err)
+	status := apperr.Status(e.Code)
+	rid := requestID(c)
+
+	args := append([]any{"code", e.Code, "status", status}, attrs...)
+	if e.Err != nil {
+		args = append(args, "err", e.Err)
+	}
+	h.logger(c).Log(c.Request.Context(), apperr.LogLevel(e.Code), e.Detail, args...)
+
+	if wantsHTML(c) {
+		h.renderHTML(c, status, "error.html", errorPage{
+			Title:     http.StatusText(status),
+			Message:   e.Detail,
+			Code:      string(e.Code),
+			RequestID: rid,
+		})
+		c.Abort()
+		return
+	}
+
+	body, mErr := json.Marshal(e.Problem(rid))
+	if mErr != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, "application/problem+json", body)
+	c.Abort()
+}
+
+// wantsHTML reports whether the client is a browser navigating to the page
+func wantsHTML(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/html")
+}
@@ -1,13 +1,20 @@
 package httpapi
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"regexp"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/apps"
 	"shopify-auth-app/internal/config"
+	"shopify-auth-app/internal/health"
+	"shopify-auth-app/internal/metrics"
+	"shopify-auth-app/internal/ratelimit"
 	"shopify-auth-app/internal/repository"
 	"shopify-auth-app/internal/shopify"
 	"strings"
@@ -25,17 +32,38 @@ func normalizeAndValidateShop(raw string) (string, bool) {
 
 type Handlers struct {
 	cfg       config.Config
-	shopRepo  *repository.ShopRepository
-	stateRepo *repository.StateRepository
+	apps      *apps.Registry
+	shopRepo  ShopStore
+	stateRepo StateStore
+	sessions  SessionStore
+	tokens    TokenExchanger
+	syncer    ShopSyncer
 	log       *slog.Logger
+	now       func() time.Time
+	cookies   *sessionCodec
+	cookie    CookiePolicy
+	metrics   *metrics.Metrics
+	ready     *health.Readiness
+	// limiter is nil when RATE_LIMIT_BACKEND=off
+	limiter ratelimit.Limiter
 }
 
-func NewHandlers(cfg config.Config, shopRepo *repository.ShopRepository, stateRepo *repository.StateRepository, logger *slog.Logger) *Handlers {
+func NewHandlers(cfg config.Config, shopRepo ShopStore, stateRepo StateStore, sessions SessionStore, tokens TokenExchanger, syncer ShopSyncer, m *metrics.Metrics, ready *health.Readiness, limiter ratelimit.Limiter, logger *slog.Logger) *Handlers {
 	return &Handlers{
 		cfg:       cfg,
+		apps:      apps.NewRegistry(cfg.Apps),
 		shopRepo:  shopRepo,
 		stateRepo: stateRepo,
+		sessions:  sessions,
+		tokens:    tokens,
+		syncer:    syncer,
 		log:       logger,
+		now:       time.Now,
+		cookies:   newSessionCodec(cfg.SessionKeys),
+		cookie:    NewCookiePolicy(cfg),
+		metrics:   m,
+		ready:     ready,
+		limiter:   limiter,
 	}
 }
 
@@ -43,19 +71,37 @@ func (h *Handlers) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// Livez only proves the process serves HTTP, it never checks dependencies: a restart would not fix them
+func (h *Handlers) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// Readyz runs the readiness checks and answers 503 when any fails or the server is shutting down.
+// Why a check failed is only logged
+func (h *Handlers) Readyz(c *gin.Context) {
+	report := h.ready.Check(c.Request.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+		for name, r := range report.Checks {
+			if r.Status != health.StatusOK {
+				h.logger(c).Warn("readiness check failed", "check", name, "err", r.Error, "latency_ms", r.LatencyMS)
+			}
+		}
+	}
+	c.JSON(status, report)
+}
+
 func (h *Handlers) Login(c *gin.Context) {
+	app := h.app(c)
 	rawShop := c.Query("shop")
 	shop, ok := normalizeAndValidateShop(rawShop)
 	if rawShop == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "missing shop query parameter. Example: /login?shop=your-store.myshopify.com",
-		})
+		h.fail(c, apperr.New(apperr.CodeMissingParameter, "missing shop query parameter. Example: /login?shop=your-store.myshopify.com"))
 		return
 	}
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid shop domain. Must match *.myshopify.com",
-		})
+		h.fail(c, apperr.New(apperr.CodeInvalidShop, "invalid shop domain. Must match *.myshopify.com"))
 		return
 	}
 
@@ -64,69 +110,85 @@ func (h *Handlers) Login(c *gin.Context) {
 	// Validate HMAC if present,this prevents unauthorized access by typing shop domain directly
 	hmacParam := c.Query("hmac")
 	if hmacParam != "" {
-		if err := shopify.ValidateHMAC(c.Request.URL.Query(), h.cfg.ShopifyAPISecret); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid hmac signature"})
+		if err := h.verifyQueryHMAC(c, app); err != nil {
+			h.fail(c, apperr.Wrap(apperr.CodeInvalidHMAC, err, "invalid hmac signature"), "shop", shop)
+			return
+		}
+		if err := h.allowShop(c, shop); err != nil {
+			h.fail(c, err, "scope", "shop", "shop", shop)
 			return
 		}
 	}
 
-	_, err := h.shopRepo.GetByDomain(ctx, shop)
-	if err == nil {
-		// Shop exists in database
+	// host is the base64 admin host the app was launched from, needed to return to the right admin
+	host := ""
+	if rawHost := c.Query("host"); rawHost != "" {
+		parsed, hErr := shopify.ParseHost(rawHost, shop)
+		if hErr != nil {
+			h.fail(c, apperr.Wrap(apperr.CodeInvalidHost, hErr, "invalid host parameter"), "shop", shop)
+			return
+		}
+		host = parsed
+	}
+
+	s, err := h.shopRepo.GetByDomain(ctx, app.APIKey, shop)
+	// installed shops opened from the Admin go straight to the app, the dashboard explains a frozen shop.
+	// needs_reauth, installing, uninstalled and redacted shops go through OAuth
+	if err == nil && (s.State == repository.StateActive || s.State == repository.StateFrozen) {
 		if hmacParam != "" {
-			sess, sErr := signSession(shop, h.cfg.SessionSecret, 15*time.Minute)
-			if sErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
-				h.log.Error("failed to sign session", "shop", shop, "err", sErr)
+			if sErr := h.startSession(c, app, shop); sErr != nil {
+				h.fail(c, sErr, "shop", shop)
 				return
 			}
-			c.SetSameSite(http.SameSiteLaxMode)
-			c.SetCookie("app_session", sess, 900, "/", "", false, true)
 
-			c.Redirect(http.StatusFound, "/dashboard?shop="+url.QueryEscape(shop))
+			h.metrics.LoginStarted("session")
+			c.Redirect(http.StatusFound, appPath(c, app, "/dashboard")+"?shop="+url.QueryEscape(shop))
 			return
 		}
 	}
 	if err != nil && err != repository.ErrNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "database error",
-		})
-		h.log.Error("db error in login get shop", "shop", shop, "err", err)
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+
+	// OAuth pages refuse to be framed, so leave the Admin iframe first and restart /login at the top level
+	if c.Query("embedded") == "1" {
+		h.metrics.LoginStarted("exit_iframe")
+		h.renderExitIframe(c, app, shop, c.Query("host"))
 		return
 	}
 
 	// 2) create nonce and register to db
 	nonce, err := newNonce()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate nonce"})
-		h.log.Error("failed to generate nonce", "err", err)
+		h.fail(c, apperr.Wrap(apperr.CodeInternal, err, "failed to generate nonce"))
 		return
 	}
 
-	if err := h.stateRepo.Create(ctx, shop, nonce, 10*time.Minute); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to persist oauth state"})
-		h.log.Error("failed to persist oauth state", "shop", shop, "nonce", nonce, "err", err)
+	if err := h.stateRepo.Create(ctx, app.APIKey, shop, nonce, host, 10*time.Minute); err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to persist oauth state"), "shop", shop)
 		return
 	}
 
 	// 3) Shopify authorize redirect to url
 	authURL, err := shopify.BuildAuthorizeURL(
 		shop,
-		h.cfg.ShopifyAPIKey,
-		h.cfg.ShopifyScopes,
-		h.cfg.CallbackURL,
+		app.APIKey,
+		app.Scopes,
+		app.CallbackURL,
 		nonce,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build authorize url"})
-		h.log.Error("failed to build authorize url", "shop", shop, "err", err)
+		h.fail(c, apperr.Wrap(apperr.CodeInternal, err, "failed to build authorize url"), "shop", shop)
 		return
 	}
 
+	h.metrics.LoginStarted("authorize")
 	c.Redirect(http.StatusFound, authURL)
 }
 
 func (h *Handlers) OAuthCallback(c *gin.Context) {
+	app := h.app(c)
 	rawShop := c.Query("shop")
 	shop, ok := normalizeAndValidateShop(rawShop)
 	code := c.Query("code")
@@ -135,108 +197,230 @@ func (h *Handlers) OAuthCallback(c *gin.Context) {
 	_ = c.Query("timestamp")
 
 	if rawShop == "" || code == "" || hmacParam == "" || state == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing required parameters"})
+		h.failCallback(c, apperr.New(apperr.CodeMissingParameter, "missing required parameters"))
 		return
 	}
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid shop"})
+		h.failCallback(c, apperr.New(apperr.CodeInvalidShop, "invalid shop"))
 		return
 	}
 
-	if err := shopify.ValidateHMAC(c.Request.URL.Query(), h.cfg.ShopifyAPISecret); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid hmac signature"})
+	if err := h.verifyQueryHMAC(c, app); err != nil {
+		h.failCallback(c, apperr.Wrap(apperr.CodeInvalidHMAC, err, "invalid hmac signature"), "shop", shop)
+		return
+	}
+	if err := h.allowShop(c, shop); err != nil {
+		h.failCallback(c, err, "scope", "shop", "shop", shop)
 		return
 	}
 
-	ctx := c.Request.Context()
+	// shop changes of the callback are the merchant's
+	ctx := withActor(c, repository.ActorMerchant)
 
 	//state validation, check nonce is valid and not expred
-	valid, err := h.stateRepo.Consume(ctx, shop, state)
+	host, valid, err := h.stateRepo.Consume(ctx, app.APIKey, shop, state)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate state"})
-		h.log.Error("failed to validate oauth state", "shop", shop, "state", state, "err", err)
+		h.failCallback(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to validate state"), "shop", shop)
 		return
 	}
 	if !valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired state parameter"})
+		h.failCallback(c, apperr.New(apperr.CodeStateExpired, "invalid or expired state parameter"), "shop", shop)
+		return
+	}
+
+	// a new or uninstalled shop is installing from here until its token is stored
+	if _, err := h.shopRepo.BeginInstall(ctx, app.APIKey, shop); err != nil {
+		h.failCallback(c, lifecycleError(err, "failed to begin install"), "shop", shop)
 		return
 	}
 
 	//token exchange convert authorization code to access token
-	tokenResp, err := shopify.ExchangeCodeForToken(shop, h.cfg.ShopifyAPIKey, h.cfg.ShopifyAPISecret, code)
+	start := time.Now()
+	tokenResp, err := h.tokens.ExchangeCodeForApp(ctx, credentials(app), shop, code)
+	h.metrics.ObserveTokenExchange(time.Since(start), err)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange token"})
-		h.log.Error("token exchange failed", "shop", shop, "err", err)
+		h.failCallback(c, apperr.Wrap(apperr.CodeTokenExchange, err, "failed to exchange token"), "shop", shop)
 		return
 	}
 
 	//save shop to database with the access token
-	_, err = h.shopRepo.Upsert(ctx, shop, tokenResp.AccessToken, tokenResp.Scope)
+	_, err = h.shopRepo.Install(ctx, app.APIKey, shop, tokenResp.AccessToken, tokenResp.Scope)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save shop"})
-		h.log.Error("failed to save shop", "shop", shop, "err", err)
+		h.failCallback(c, lifecycleError(err, "failed to save shop"), "shop", shop)
 		return
 	}
 
-	sess, err := signSession(shop, h.cfg.SessionSecret, 15*time.Minute)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
-		h.log.Error("failed to sign session", "shop", shop, "err", err)
+	// name, plan, currency... are fetched after the redirect, the merchant does not wait for them
+	h.syncer.Enqueue(app.APIKey, shop)
+
+	if err := h.startSession(c, app, shop); err != nil {
+		h.failCallback(c, err, "shop", shop)
 		return
 	}
 
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("app_session", sess, 900, "/", "", false, true)
+	h.metrics.CallbackOutcome("success")
+	c.Redirect(http.StatusFound, h.postInstallRedirect(c, app, shop, host))
+}
 
-	c.Redirect(http.StatusFound, "/dashboard?shop="+url.QueryEscape(shop))
+// failCallback counts the rejected callback by its error code before failing the request
+func (h *Handlers) failCallback(c *gin.Context, err error, attrs ...any) {
+	h.metrics.CallbackOutcome(string(apperr.From(err).Code))
+	h.fail(c, err, attrs...)
 }
 
 // dummy dashboard
 func (h *Handlers) Dashboard(c *gin.Context) {
+	app := h.app(c)
 	rawShop := c.Query("shop")
 	shop, ok := normalizeAndValidateShop(rawShop)
 	if rawShop == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing shop"})
+		h.fail(c, apperr.New(apperr.CodeMissingParameter, "missing shop"))
 		return
 	}
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid shop"})
+		h.fail(c, apperr.New(apperr.CodeInvalidShop, "invalid shop"))
 		return
 	}
 
-	cookie, err := c.Cookie("app_session")
+	cookie, err := c.Cookie(h.cookieName(app))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing session"})
+		h.fail(c, apperr.New(apperr.CodeSessionMissing, "missing session"), "shop", shop)
 		return
 	}
 
-	sessShop, err := verifySession(cookie, h.cfg.SessionSecret)
+	payload, err := h.cookies.open(cookie, h.now())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		h.fail(c, apperr.Wrap(apperr.CodeSessionInvalid, err, "invalid session"), "shop", shop)
 		return
 	}
-	if sessShop != shop {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "session-shop mismatch"})
+	if payload.App != app.APIKey {
+		h.fail(c, apperr.New(apperr.CodeSessionInvalid, "session belongs to another app"), "shop", shop)
+		return
+	}
+	if payload.Shop != shop {
+		h.fail(c, apperr.New(apperr.CodeSessionShopMismatch, "session-shop mismatch"), "shop", shop)
 		return
 	}
 
 	ctx := c.Request.Context()
-	s, err := h.shopRepo.GetByDomain(ctx, shop)
+
+	// the cookie is only a reference, the server-side row decides whether the session is still alive
+	sess, err := h.sessions.GetActive(ctx, payload.SID)
 	if err == repository.ErrNotFound {
-		c.JSON(http.StatusNotFound, gin.H{"error": "shop not installed"})
+		h.fail(c, apperr.New(apperr.CodeSessionInvalid, "session revoked or expired"), "shop", shop)
 		return
 	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
-		h.log.Error("db error in dashboard get shop", "shop", shop, "err", err)
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+	if sess.ShopDomain != shop || sess.APIKey != app.APIKey {
+		h.fail(c, apperr.New(apperr.CodeSessionShopMismatch, "session-shop mismatch"), "shop", shop)
 		return
 	}
+	if err := h.sessions.Touch(ctx, sess.ID); err != nil {
+		h.logger(c).Error("failed to touch session", "shop", shop, "err", err)
+	}
 
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	c.String(http.StatusOK,
-		"<h1>Dashboard</h1><p>Shop: %s</p><p>Scopes: %s</p><p>Installed: %s</p>",
-		s.ShopDomain, s.Scopes, s.InstalledAt.Format(time.RFC3339),
-	)
+	s, err := h.shopRepo.GetByDomain(ctx, app.APIKey, shop)
+	if err == repository.ErrNotFound {
+		h.fail(c, apperr.New(apperr.CodeShopNotInstalled, "shop not installed"), "shop", shop)
+		return
+	}
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+
+	switch s.State {
+	case repository.StateActive, repository.StateFrozen:
+	case repository.StateNeedsReauth:
+		// Shopify rejects the stored token, send the merchant through OAuth again
+		c.Redirect(http.StatusFound, appPath(c, app, "/login")+"?shop="+url.QueryEscape(shop))
+		return
+	default:
+		h.fail(c, shopStateError(s), "shop", shop, "shop_state", s.State)
+		return
+	}
+
+	h.renderHTML(c, http.StatusOK, "dashboard.html", gin.H{
+		"Shop":   s,
+		"Scopes": s.ScopeList(),
+		"Frozen": s.State == repository.StateFrozen,
+	})
+}
+
+// shopStateError is how a request for a shop that is not active fails
+func shopStateError(s *repository.Shop) error {
+	switch s.State {
+	case repository.StateActive:
+		return nil
+	case repository.StateFrozen:
+		return apperr.New(apperr.CodeShopFrozen, "shop is frozen, its Shopify plan is unpaid")
+	case repository.StateNeedsReauth:
+		return apperr.New(apperr.CodeShopNeedsReauth, "shop must open the app again to re-authorize it")
+	default:
+		return apperr.New(apperr.CodeShopNotInstalled, "shop not installed")
+	}
+}
+
+// lifecycleError maps a failed lifecycle write: a transition the lifecycle refuses is a conflict
+func lifecycleError(err error, detail string) error {
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		return apperr.Wrap(apperr.CodeInvalidShopState, err, detail)
+	}
+	return apperr.Wrap(apperr.CodeDatabase, err, detail)
+}
+
+// withActor attributes the shop changes made with the returned context to name, from the client's IP
+func withActor(c *gin.Context, name string) context.Context {
+	return repository.WithActor(c.Request.Context(), repository.Actor{Name: name, IP: c.ClientIP()})
+}
+
+// Logout revokes the current server-side session and clears the cookie
+func (h *Handlers) Logout(c *gin.Context) {
+	app := h.app(c)
+	if cookie, err := c.Cookie(h.cookieName(app)); err == nil {
+		// an expired cookie still identifies a session worth revoking
+		if payload, vErr := h.cookies.open(cookie, time.Time{}); vErr == nil && payload.App == app.APIKey {
+			if err := h.sessions.Revoke(c.Request.Context(), payload.SID); err != nil {
+				h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to revoke session"), "shop", payload.Shop)
+				return
+			}
+		}
+	}
+
+	h.cookie.clear(c, h.cookieName(app))
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// startSession persists a new server-side session for shop in app and sets the cookie referencing it.
+// Failures are *apperr.Error: only storing the session is a database error
+func (h *Handlers) startSession(c *gin.Context, app *config.App, shop string) error {
+	sid, err := newNonce()
+	if err != nil {
+		return apperr.Wrap(apperr.CodeInternal, err, "failed to generate session id")
+	}
+	expiresAt := h.now().Add(sessionTTL)
+
+	if err := h.sessions.Create(c.Request.Context(), repository.Session{
+		ID:         sid,
+		APIKey:     app.APIKey,
+		ShopDomain: shop,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		ExpiresAt:  expiresAt,
+	}); err != nil {
+		return apperr.Wrap(apperr.CodeDatabase, err, "failed to create session")
+	}
+
+	value, err := h.cookies.seal(sid, app.APIKey, shop, expiresAt)
+	if err != nil {
+		return apperr.Wrap(apperr.CodeInternal, err, "failed to seal session cookie")
+	}
+
+	h.cookie.set(c, h.cookieName(app), value, int(sessionTTL.Seconds()))
+	return nil
 }
 
 func newNonce() (string, error) {
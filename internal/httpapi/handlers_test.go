@@ -0,0 +1,2114 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/config"
+	"shopify-auth-app/internal/health"
+	"shopify-auth-app/internal/metrics"
+	"shopify-auth-app/internal/ratelimit"
+	"shopify-auth-app/internal/redact"
+	"shopify-auth-app/internal/repository"
+	"shopify-auth-app/internal/shopify"
+	"shopify-auth-app/internal/shopify/shopifytest"
+	"shopify-auth-app/internal/telemetry"
+	"shopify-auth-app/internal/telemetry/telemetrytest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testShop   = shopifytest.DefaultShop
+	testAPIKey = shopifytest.DefaultAPIKey
+)
+
+var errDB = errors.New("connection refused")
+
+// testClock is a deterministic clock shared by the handlers and the in-memory stores
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+type memShops struct {
+	mu    sync.Mutex
+	shops map[string]repository.Shop
+	now   func() time.Time
+
+	events []repository.ShopEvent
+
+	getErr    error
+	upsertErr error
+}
+
+// shopKey is how memShops keys an installation: one per app and shop
+func shopKey(apiKey, shopDomain string) string {
+	return apiKey + "/" + shopDomain
+}
+
+func (m *memShops) GetByDomain(ctx context.Context, apiKey, shopDomain string) (*repository.Shop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	s, ok := m.shops[shopKey(apiKey, shopDomain)]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &s, nil
+}
+
+func (m *memShops) BeginInstall(ctx context.Context, apiKey, shopDomain string) (*repository.Shop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upsertErr != nil {
+		return nil, m.upsertErr
+	}
+	s, ok := m.shops[shopKey(apiKey, shopDomain)]
+	if !ok {
+		s = m.newShop(apiKey, shopDomain, repository.StateInstalling)
+		m.record(ctx, s, repository.EventInstallStarted, nil)
+	} else if !s.State.Installed() && s.State != repository.StateInstalling {
+		from := s.State
+		m.setState(&s, repository.StateInstalling)
+		m.record(ctx, s, repository.EventInstallStarted, map[string]any{"from": from})
+	}
+	m.shops[shopKey(apiKey, shopDomain)] = s
+	return &s, nil
+}
+
+func (m *memShops) Install(ctx context.Context, apiKey, shopDomain, token, scopes string) (*repository.Shop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upsertErr != nil {
+		return nil, m.upsertErr
+	}
+	s, ok := m.shops[shopKey(apiKey, shopDomain)]
+	event, meta := repository.EventInstalled, map[string]any{"scopes": scopes}
+	if !ok {
+		s = m.newShop(apiKey, shopDomain, repository.StateActive)
+	} else {
+		event, meta["from"] = repository.EventReauthorized, s.State
+		next := repository.StateActive
+		if s.State == repository.StateFrozen {
+			next = repository.StateFrozen
+		}
+		if s.State != next && !s.State.CanTransitionTo(next) {
+			return nil, &repository.TransitionError{From: s.State, To: next}
+		}
+		if s.State == repository.StateInstalling {
+			event = repository.EventInstalled
+			if s.UninstalledAt != nil && s.UninstalledAt.After(s.InstalledAt) {
+				s.ReinstallCount++
+				event, meta["reinstall_count"] = repository.EventReinstalled, s.ReinstallCount
+			}
+			s.InstalledAt = m.now()
+		} else if s.Scopes != scopes {
+			meta["previous_scopes"] = s.Scopes
+		}
+		if s.State != next {
+			m.setState(&s, next)
+		}
+	}
+	s.OfflineAccessToken, s.Scopes, s.UpdatedAt = token, scopes, m.now()
+	m.shops[shopKey(apiKey, shopDomain)] = s
+	m.record(ctx, s, event, meta)
+	return &s, nil
+}
+
+func (m *memShops) Transition(ctx context.Context, apiKey, shopDomain string, to repository.ShopState) (*repository.Shop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upsertErr != nil {
+		return nil, m.upsertErr
+	}
+	s, ok := m.shops[shopKey(apiKey, shopDomain)]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	if s.State == to {
+		return &s, nil
+	}
+	if to == repository.StateInstalling || (to == repository.StateActive && !s.State.HasToken()) || !s.State.CanTransitionTo(to) {
+		return nil, &repository.TransitionError{From: s.State, To: to}
+	}
+	from := s.State
+	m.setState(&s, to)
+	m.shops[shopKey(apiKey, shopDomain)] = s
+	m.record(ctx, s, transitionEvents[to], map[string]any{"from": from})
+	if to == repository.StateRedacted {
+		for i, e := range m.events {
+			if e.APIKey == apiKey && e.ShopDomain == shopDomain {
+				m.events[i].IP, m.events[i].Metadata = "", map[string]any{}
+			}
+		}
+	}
+	return &s, nil
+}
+
+// transitionEvents mirrors the event ShopRepository.Transition records for each target state
+var transitionEvents = map[repository.ShopState]repository.EventType{
+	repository.StateActive:      repository.EventUnfrozen,
+	repository.StateFrozen:      repository.EventFrozen,
+	repository.StateNeedsReauth: repository.EventReauthRequired,
+	repository.StateUninstalled: repository.EventUninstalled,
+	repository.StateRedacted:    repository.EventRedacted,
+}
+
+func (m *memShops) record(ctx context.Context, s repository.Shop, typ repository.EventType, meta map[string]any) {
+	if meta == nil {
+		meta = map[string]any{}
+	}
+	a := repository.ActorFrom(ctx)
+	m.events = append(m.events, repository.ShopEvent{
+		ID: int64(len(m.events) + 1), APIKey: s.APIKey, ShopDomain: s.ShopDomain,
+		Type: typ, Actor: a.Name, IP: a.IP, Metadata: meta, CreatedAt: m.now(),
+	})
+}
+
+func (m *memShops) Timeline(ctx context.Context, apiKey, shopDomain string, before int64, limit int) ([]repository.ShopEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	var out []repository.ShopEvent
+	for i := len(m.events) - 1; i >= 0 && len(out) < limit; i-- {
+		e := m.events[i]
+		if e.APIKey == apiKey && e.ShopDomain == shopDomain && (before == 0 || e.ID < before) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// eventTypes lists the types of shopDomain's events of the default app, oldest first
+func (m *memShops) eventTypes(shopDomain string) []repository.EventType {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []repository.EventType
+	for _, e := range m.events {
+		if e.APIKey == testAPIKey && e.ShopDomain == shopDomain {
+			out = append(out, e.Type)
+		}
+	}
+	return out
+}
+
+func (m *memShops) newShop(apiKey, shopDomain string, state repository.ShopState) repository.Shop {
+	now := m.now()
+	return repository.Shop{
+		ID: int64(len(m.shops) + 1), APIKey: apiKey, ShopDomain: shopDomain, State: state,
+		InstalledAt: now, FirstInstalledAt: now, StateChangedAt: now, UpdatedAt: now,
+	}
+}
+
+// setState mirrors the columns ShopRepository rewrites when a shop enters a state
+func (m *memShops) setState(s *repository.Shop, to repository.ShopState) {
+	now := m.now()
+	s.State, s.StateChangedAt, s.UpdatedAt = to, now, now
+	switch to {
+	case repository.StateUninstalled:
+		s.OfflineAccessToken, s.UninstalledAt = "", &now
+	case repository.StateRedacted:
+		s.OfflineAccessToken, s.Scopes = "", ""
+		s.ShopMetadata, s.MetadataSyncedAt = repository.ShopMetadata{}, nil
+	}
+}
+
+func (m *memShops) UpdateScopes(ctx context.Context, apiKey, shopDomain, scopes string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.shops[shopKey(apiKey, shopDomain)]
+	if !ok || !s.State.Installed() {
+		return repository.ErrNotFound
+	}
+	if s.Scopes != scopes {
+		m.record(ctx, s, repository.EventScopesChanged, map[string]any{"previous": s.Scopes, "current": scopes})
+	}
+	s.Scopes = scopes
+	m.shops[shopKey(apiKey, shopDomain)] = s
+	return nil
+}
+
+func (m *memShops) UpdateMetadata(ctx context.Context, apiKey, shopDomain string, meta repository.ShopMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.shops[shopKey(apiKey, shopDomain)]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	now := m.now()
+	s.ShopMetadata, s.MetadataSyncedAt = meta, &now
+	m.shops[shopKey(apiKey, shopDomain)] = s
+	return nil
+}
+
+type memSessions struct {
+	mu       sync.Mutex
+	sessions map[string]repository.Session
+	now      func() time.Time
+
+	getErr    error
+	createErr error
+}
+
+func (m *memSessions) Create(ctx context.Context, s repository.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.createErr != nil {
+		return m.createErr
+	}
+	s.CreatedAt, s.LastSeenAt = m.now(), m.now()
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *memSessions) GetActive(ctx context.Context, id string) (*repository.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	s, ok := m.sessions[id]
+	if !ok || s.RevokedAt != nil || !m.now().Before(s.ExpiresAt) {
+		return nil, repository.ErrNotFound
+	}
+	return &s, nil
+}
+
+func (m *memSessions) Touch(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		s.LastSeenAt = m.now()
+		m.sessions[id] = s
+	}
+	return nil
+}
+
+func (m *memSessions) Revoke(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok && s.RevokedAt == nil {
+		now := m.now()
+		s.RevokedAt = &now
+		m.sessions[id] = s
+	}
+	return nil
+}
+
+func (m *memSessions) RevokeAllForShop(ctx context.Context, apiKey, shopDomain string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	now := m.now()
+	for id, s := range m.sessions {
+		if s.APIKey == apiKey && s.ShopDomain == shopDomain && s.RevokedAt == nil {
+			s.RevokedAt = &now
+			m.sessions[id] = s
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memSessions) active(shopDomain string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, s := range m.sessions {
+		if s.ShopDomain == shopDomain && s.RevokedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+type memState struct {
+	apiKey    string
+	shop      string
+	host      string
+	expiresAt time.Time
+}
+
+type memStates struct {
+	mu     sync.Mutex
+	states map[string]memState
+	now    func() time.Time
+
+	createErr  error
+	consumeErr error
+}
+
+func (m *memStates) Create(ctx context.Context, apiKey, shopDomain, nonce, host string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.createErr != nil {
+		return m.createErr
+	}
+	m.states[nonce] = memState{apiKey: apiKey, shop: shopDomain, host: host, expiresAt: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *memStates) Consume(ctx context.Context, apiKey, shopDomain, nonce string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.consumeErr != nil {
+		return "", false, m.consumeErr
+	}
+	st, ok := m.states[nonce]
+	if !ok || st.apiKey != apiKey || st.shop != shopDomain || !m.now().Before(st.expiresAt) {
+		return "", false, nil
+	}
+	delete(m.states, nonce)
+	return st.host, true, nil
+}
+
+// onlyNonce returns the single stored nonce, failing the test otherwise
+func (m *memStates) onlyNonce(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.states) != 1 {
+		t.Fatalf("expected exactly one stored state, got %d", len(m.states))
+	}
+	for nonce := range m.states {
+		return nonce
+	}
+	return ""
+}
+
+type fakeExchanger struct {
+	resp  *shopify.AccessTokenResponse
+	err   error
+	calls int
+	// app is the credentials of the last call
+	app shopify.Credentials
+}
+
+func (f *fakeExchanger) ExchangeCodeForApp(ctx context.Context, app shopify.Credentials, shopDomain, code string) (*shopify.AccessTokenResponse, error) {
+	f.calls++
+	f.app = app
+	return f.resp, f.err
+}
+
+// fakeSyncer records the shops enqueued for a metadata sync
+type fakeSyncer struct {
+	mu     sync.Mutex
+	queued []string
+}
+
+func (f *fakeSyncer) Enqueue(apiKey, shopDomain string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queued = append(f.queued, shopKey(apiKey, shopDomain))
+}
+
+type harness struct {
+	cfg      config.Config
+	clock    *testClock
+	shops    *memShops
+	states   *memStates
+	sessions *memSessions
+	tokens   *fakeExchanger
+	syncer   *fakeSyncer
+	h        *Handlers
+	router   *gin.Engine
+	logs     *bytes.Buffer
+	ready    *health.Readiness
+	limiter  ratelimit.Limiter
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	hs := &harness{
+		cfg: config.Config{
+			Apps: []config.App{{
+				Name:        config.DefaultAppName,
+				APIKey:      testAPIKey,
+				APISecret:   shopifytest.DefaultAPISecret,
+				Scopes:      "read_products",
+				CallbackURL: "https://app.example.com/auth/callback",
+			}},
+			SessionKeys:    []config.SessionKey{{ID: "k1", Secret: "session-secret"}},
+			MetricsEnabled: true,
+			MetricsToken:   "metrics-token",
+		},
+		clock:    clock,
+		shops:    &memShops{shops: map[string]repository.Shop{}, now: clock.Now},
+		states:   &memStates{states: map[string]memState{}, now: clock.Now},
+		sessions: &memSessions{sessions: map[string]repository.Session{}, now: clock.Now},
+		tokens:   &fakeExchanger{resp: &shopify.AccessTokenResponse{AccessToken: "shpat_test", Scope: "read_products"}},
+		syncer:   &fakeSyncer{},
+		ready:    health.NewReadiness(time.Second),
+	}
+	hs.build(hs.tokens)
+	return hs
+}
+
+// build (re)creates handlers and router with the given token exchanger
+func (hs *harness) build(tokens TokenExchanger) {
+	hs.logs = &bytes.Buffer{}
+	logger := slog.New(redact.NewHandler(slog.NewJSONHandler(hs.logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	hs.h = NewHandlers(hs.cfg, hs.shops, hs.states, hs.sessions, tokens, hs.syncer, metrics.New(), hs.ready, hs.limiter, logger)
+	hs.h.now = hs.clock.Now
+	hs.router = NewRouter(hs.h)
+}
+
+func (hs *harness) get(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (hs *harness) install(shop string) {
+	_, _ = hs.shops.Install(context.Background(), testAPIKey, shop, "shpat_existing", "read_products")
+}
+
+// sessionCookie creates a server-side session for shop and returns the cookie referencing it
+func (hs *harness) sessionCookie(t *testing.T, shop string) *http.Cookie {
+	t.Helper()
+	sid := fmt.Sprintf("sid-%d", len(hs.sessions.sessions)+1)
+	exp := hs.clock.Now().Add(sessionTTL)
+	_ = hs.sessions.Create(context.Background(), repository.Session{ID: sid, APIKey: testAPIKey, ShopDomain: shop, ExpiresAt: exp})
+
+	v, err := hs.h.cookies.seal(sid, testAPIKey, shop, exp)
+	if err != nil {
+		t.Fatalf("sign session: %v", err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: v}
+}
+
+func (hs *harness) post(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// signedQuery encodes v with a Shopify hmac computed with secret
+func signedQuery(v url.Values, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(v.Encode()))
+	out := url.Values{}
+	for k, vals := range v {
+		out[k] = vals
+	}
+	out.Set("hmac", hex.EncodeToString(mac.Sum(nil)))
+	return out.Encode()
+}
+
+func (hs *harness) callbackQuery(shop, state string) string {
+	v := url.Values{}
+	v.Set("shop", shop)
+	v.Set("code", "auth-code")
+	v.Set("state", state)
+	v.Set("timestamp", "1735732800")
+	return signedQuery(v, hs.cfg.Apps[0].APISecret)
+}
+
+func findCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func assertStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d (body: %s)", rec.Code, want, rec.Body.String())
+	}
+}
+
+func TestLogin_Validation(t *testing.T) {
+	hs := newHarness(t)
+
+	assertStatus(t, hs.get("/login"), http.StatusBadRequest)
+	assertStatus(t, hs.get("/login?shop=evil.com"), http.StatusBadRequest)
+	assertStatus(t, hs.get("/login?shop="+testShop+"&hmac=deadbeef&timestamp=1"), http.StatusUnauthorized)
+}
+
+func TestLogin_StartsOAuthForNewShop(t *testing.T) {
+	hs := newHarness(t)
+
+	rec := hs.get("/login?shop=" + strings.ToUpper(testShop))
+	assertStatus(t, rec, http.StatusFound)
+
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	if loc.Host != testShop || loc.Path != "/admin/oauth/authorize" {
+		t.Fatalf("unexpected redirect %s", loc)
+	}
+	q := loc.Query()
+	if q.Get("client_id") != hs.cfg.Apps[0].APIKey || q.Get("redirect_uri") != hs.cfg.Apps[0].CallbackURL {
+		t.Fatalf("unexpected authorize params %v", q)
+	}
+	if q.Get("state") != hs.states.onlyNonce(t) {
+		t.Fatalf("state in redirect does not match stored nonce")
+	}
+}
+
+func TestLogin_InstalledShopWithoutHMACStartsOAuth(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+
+	rec := hs.get("/login?shop=" + testShop)
+	assertStatus(t, rec, http.StatusFound)
+	if !strings.Contains(rec.Header().Get("Location"), "/admin/oauth/authorize") {
+		t.Fatalf("expected oauth redirect, got %s", rec.Header().Get("Location"))
+	}
+	if findCookie(rec, sessionCookieName) != nil {
+		t.Fatalf("unsigned request must not get a session")
+	}
+}
+
+func TestLogin_InstalledShopFastPath(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+
+	v := url.Values{}
+	v.Set("shop", testShop)
+	v.Set("timestamp", "1735732800")
+	rec := hs.get("/login?" + signedQuery(v, hs.cfg.Apps[0].APISecret))
+	assertStatus(t, rec, http.StatusFound)
+
+	if got := rec.Header().Get("Location"); got != "/dashboard?shop="+url.QueryEscape(testShop) {
+		t.Fatalf("unexpected redirect %s", got)
+	}
+	if findCookie(rec, sessionCookieName) == nil {
+		t.Fatalf("expected session cookie")
+	}
+	if len(hs.states.states) != 0 {
+		t.Fatalf("fast path must not create oauth state")
+	}
+}
+
+func TestLogin_StoreErrors(t *testing.T) {
+	hs := newHarness(t)
+	hs.shops.getErr = errDB
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusInternalServerError)
+
+	hs = newHarness(t)
+	hs.states.createErr = errDB
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusInternalServerError)
+}
+
+func TestOAuthCallback_Validation(t *testing.T) {
+	hs := newHarness(t)
+
+	assertStatus(t, hs.get("/auth/callback"), http.StatusBadRequest)
+	assertStatus(t, hs.get("/auth/callback?shop="+testShop+"&code=c&state=s"), http.StatusBadRequest)
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery("evil.com", "s")), http.StatusBadRequest)
+	assertStatus(t, hs.get("/auth/callback?shop="+testShop+"&code=c&state=s&hmac=deadbeef"), http.StatusUnauthorized)
+}
+
+func TestOAuthCallback_UnknownState(t *testing.T) {
+	hs := newHarness(t)
+
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery(testShop, "never-issued")), http.StatusUnauthorized)
+	if hs.tokens.calls != 0 {
+		t.Fatalf("token exchange must not run for an unknown state")
+	}
+}
+
+func TestOAuthCallback_ExpiredState(t *testing.T) {
+	hs := newHarness(t)
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	nonce := hs.states.onlyNonce(t)
+
+	hs.clock.Advance(10*time.Minute + time.Second)
+
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery(testShop, nonce)), http.StatusUnauthorized)
+	if hs.tokens.calls != 0 {
+		t.Fatalf("token exchange must not run for an expired state")
+	}
+}
+
+func TestOAuthCallback_StateForOtherShop(t *testing.T) {
+	hs := newHarness(t)
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	nonce := hs.states.onlyNonce(t)
+
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery("other-store.myshopify.com", nonce)), http.StatusUnauthorized)
+}
+
+func TestOAuthCallback_Failures(t *testing.T) {
+	cases := map[string]struct {
+		setup  func(hs *harness)
+		status int
+	}{
+		"state store error": {func(hs *harness) { hs.states.consumeErr = errDB }, http.StatusInternalServerError},
+		"token exchange":    {func(hs *harness) { hs.tokens.err = errors.New("shopify returned status 400") }, http.StatusBadGateway},
+		"upsert error":      {func(hs *harness) { hs.shops.upsertErr = errDB }, http.StatusInternalServerError},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			hs := newHarness(t)
+			assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+			nonce := hs.states.onlyNonce(t)
+			tc.setup(hs)
+
+			rec := hs.get("/auth/callback?" + hs.callbackQuery(testShop, nonce))
+			assertStatus(t, rec, tc.status)
+			if findCookie(rec, sessionCookieName) != nil {
+				t.Fatalf("failed callback must not set a session")
+			}
+		})
+	}
+}
+
+func TestOAuthCallback_Success(t *testing.T) {
+	hs := newHarness(t)
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	nonce := hs.states.onlyNonce(t)
+
+	rec := hs.get("/auth/callback?" + hs.callbackQuery(testShop, nonce))
+	assertStatus(t, rec, http.StatusFound)
+	if findCookie(rec, sessionCookieName) == nil {
+		t.Fatalf("expected session cookie")
+	}
+
+	s, err := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if err != nil {
+		t.Fatalf("shop not stored: %v", err)
+	}
+	if s.OfflineAccessToken != "shpat_test" {
+		t.Fatalf("unexpected token %q", s.OfflineAccessToken)
+	}
+	if len(hs.syncer.queued) != 1 || hs.syncer.queued[0] != shopKey(testAPIKey, testShop) {
+		t.Fatalf("metadata sync not enqueued: %v", hs.syncer.queued)
+	}
+
+	// state is single use
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery(testShop, nonce)), http.StatusUnauthorized)
+}
+
+func TestDashboard(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+
+	assertStatus(t, hs.get("/dashboard"), http.StatusBadRequest)
+	assertStatus(t, hs.get("/dashboard?shop=evil.com"), http.StatusBadRequest)
+	assertStatus(t, hs.get("/dashboard?shop="+testShop), http.StatusUnauthorized)
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, &http.Cookie{Name: sessionCookieName, Value: "garbage"}), http.StatusUnauthorized)
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, hs.sessionCookie(t, "other-store.myshopify.com")), http.StatusUnauthorized)
+
+	rec := hs.get("/dashboard?shop="+testShop, hs.sessionCookie(t, testShop))
+	assertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), testShop) {
+		t.Fatalf("dashboard does not show shop: %s", rec.Body.String())
+	}
+}
+
+func TestDashboard_ExpiredSession(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+	cookie := hs.sessionCookie(t, testShop)
+
+	hs.clock.Advance(15*time.Minute + time.Second)
+
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusUnauthorized)
+}
+
+func TestDashboard_StoreErrors(t *testing.T) {
+	hs := newHarness(t)
+	cookie := hs.sessionCookie(t, testShop)
+
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusNotFound)
+
+	hs.shops.getErr = errDB
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusInternalServerError)
+
+	hs.sessions.getErr = errDB
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusInternalServerError)
+}
+
+func TestDashboard_RevokedSession(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+	cookie := hs.sessionCookie(t, testShop)
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusOK)
+
+	if _, err := hs.sessions.RevokeAllForShop(context.Background(), testAPIKey, testShop); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusUnauthorized)
+}
+
+func TestLogout(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+	cookie := hs.sessionCookie(t, testShop)
+
+	rec := hs.post("/logout", cookie)
+	assertStatus(t, rec, http.StatusOK)
+	if c := findCookie(rec, sessionCookieName); c == nil || c.MaxAge >= 0 {
+		t.Fatalf("expected session cookie to be cleared")
+	}
+	if hs.sessions.active(testShop) != 0 {
+		t.Fatalf("expected session to be revoked")
+	}
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusUnauthorized)
+
+	// logging out without a session is a no-op
+	assertStatus(t, hs.post("/logout"), http.StatusOK)
+}
+
+func TestAppUninstalledWebhook(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+
+	hs := newHarness(t)
+	hs.install(testShop)
+	hs.sessionCookie(t, testShop)
+	hs.sessionCookie(t, testShop)
+
+	req, err := fake.NewWebhookRequest("/webhooks/app/uninstalled", "app/uninstalled", testShop, map[string]any{"domain": testShop})
+	if err != nil {
+		t.Fatalf("new webhook: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusOK)
+
+	if hs.sessions.active(testShop) != 0 {
+		t.Fatalf("expected all sessions to be revoked on uninstall")
+	}
+	s, err := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if err != nil {
+		t.Fatalf("uninstalled shop must be kept: %v", err)
+	}
+	if s.State != repository.StateUninstalled || s.OfflineAccessToken != "" || s.UninstalledAt == nil {
+		t.Fatalf("expected an uninstalled shop without token, got %+v", s)
+	}
+
+	// a retried delivery is acknowledged
+	req, err = fake.NewWebhookRequest("/webhooks/app/uninstalled", "app/uninstalled", testShop, map[string]any{"domain": testShop})
+	if err != nil {
+		t.Fatalf("new webhook: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusOK)
+}
+
+// TestInstallRoundTrip drives Login -> Shopify approval -> OAuthCallback -> Dashboard against the fake Shopify
+func TestInstallRoundTrip(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+
+	hs := newHarness(t)
+	hs.build(fake.Client())
+
+	rec := hs.get("/login?shop=" + testShop)
+	assertStatus(t, rec, http.StatusFound)
+
+	callbackURL, err := fake.Approve(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	cb, _ := url.Parse(callbackURL)
+
+	rec = hs.get(cb.RequestURI())
+	assertStatus(t, rec, http.StatusFound)
+	cookie := findCookie(rec, sessionCookieName)
+	if cookie == nil {
+		t.Fatalf("expected session cookie")
+	}
+
+	rec = hs.get(rec.Header().Get("Location"), cookie)
+	assertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "read_products") {
+		t.Fatalf("dashboard does not show granted scopes: %s", rec.Body.String())
+	}
+}
+
+func TestAppScopesUpdateWebhook(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+
+	hs := newHarness(t)
+	hs.install(testShop)
+
+	deliver := func(payload any, tamper bool) *httptest.ResponseRecorder {
+		req, err := fake.NewWebhookRequest("/webhooks/app/scopes_update", "app/scopes_update", testShop, payload)
+		if err != nil {
+			t.Fatalf("new webhook: %v", err)
+		}
+		if tamper {
+			req.Header.Set("X-Shopify-Hmac-Sha256", fake.SignWebhook([]byte("other")))
+		}
+		rec := httptest.NewRecorder()
+		hs.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	payload := map[string]any{"previous": []string{"read_products"}, "current": []string{"read_orders"}}
+	assertStatus(t, deliver(payload, true), http.StatusUnauthorized)
+	assertStatus(t, deliver(payload, false), http.StatusOK)
+
+	s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if s.Scopes != "read_orders" {
+		t.Fatalf("scopes not updated: %q", s.Scopes)
+	}
+}
+
+func TestShopUpdateWebhook(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+
+	hs := newHarness(t)
+	hs.install(testShop)
+	cookie := hs.sessionCookie(t, testShop)
+
+	rec := hs.get("/dashboard?shop="+testShop, cookie)
+	assertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "Not synced from Shopify yet") {
+		t.Fatalf("dashboard should say metadata is missing: %s", rec.Body.String())
+	}
+
+	for _, shop := range []string{testShop, "unknown-store.myshopify.com"} {
+		req, err := fake.NewWebhookRequest("/webhooks/shop/update", "shop/update", shop, map[string]any{
+			"name":              "Renamed <Store>",
+			"email":             "owner@example.com",
+			"customer_email":    "support@example.com",
+			"plan_display_name": "Shopify Plus",
+			"currency":          "EUR",
+			"iana_timezone":     "Europe/Berlin",
+			"domain":            "Shop.Example.com",
+		})
+		if err != nil {
+			t.Fatalf("new webhook: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		hs.router.ServeHTTP(rec, req)
+		// unknown shops are acknowledged too, Shopify would retry otherwise
+		assertStatus(t, rec, http.StatusOK)
+	}
+
+	s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if s.Name != "Renamed <Store>" || s.PlanName != "Shopify Plus" || s.PrimaryDomain != "shop.example.com" || s.MetadataSyncedAt == nil {
+		t.Fatalf("metadata not stored: %+v", s)
+	}
+
+	rec = hs.get("/dashboard?shop="+testShop, cookie)
+	assertStatus(t, rec, http.StatusOK)
+	for _, want := range []string{"Renamed &lt;Store&gt;", "owner@example.com", "Shopify Plus", "EUR", "Europe/Berlin", "shop.example.com"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("dashboard misses %q: %s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestSessionCookiePolicy(t *testing.T) {
+	fastPath := func(hs *harness) string {
+		hs.install(testShop)
+		v := url.Values{}
+		v.Set("shop", testShop)
+		v.Set("timestamp", "1735732800")
+		rec := hs.get("/login?" + signedQuery(v, hs.cfg.Apps[0].APISecret))
+		assertStatus(t, rec, http.StatusFound)
+		return rec.Header().Get("Set-Cookie")
+	}
+
+	dev := fastPath(newHarness(t))
+	if !strings.Contains(dev, "SameSite=Lax") || strings.Contains(dev, "Secure") || strings.Contains(dev, "Partitioned") {
+		t.Fatalf("unexpected development cookie: %s", dev)
+	}
+
+	hs := newHarness(t)
+	hs.cfg.AppEnv = "production"
+	hs.build(hs.tokens)
+	prod := fastPath(hs)
+	for _, attr := range []string{"Secure", "SameSite=None", "Partitioned", "HttpOnly"} {
+		if !strings.Contains(prod, attr) {
+			t.Fatalf("production cookie missing %s: %s", attr, prod)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	hs := newHarness(t)
+	hs.install(testShop)
+
+	rec := hs.get("/login?shop=" + testShop)
+	if got := rec.Header().Get("Content-Security-Policy"); got != strictCSP {
+		t.Fatalf("unsigned request must get the strict policy, got %q", got)
+	}
+	if rec.Header().Get("X-Frame-Options") != "DENY" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("missing standard headers: %v", rec.Header())
+	}
+
+	want := "frame-ancestors https://" + testShop + " https://admin.shopify.com"
+
+	v := url.Values{}
+	v.Set("shop", testShop)
+	v.Set("timestamp", "1735732800")
+	rec = hs.get("/login?" + signedQuery(v, hs.cfg.Apps[0].APISecret))
+	if got := rec.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, want) {
+		t.Fatalf("signed request: csp = %q", got)
+	}
+	if rec.Header().Get("X-Frame-Options") != "" {
+		t.Fatalf("X-Frame-Options would block the admin iframe")
+	}
+
+	rec = hs.get("/dashboard?shop="+testShop, hs.sessionCookie(t, testShop))
+	if got := rec.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, want) {
+		t.Fatalf("dashboard: csp = %q", got)
+	}
+
+	// a session for one shop does not let another shop frame the app
+	rec = hs.get("/dashboard?shop=evil-store.myshopify.com", hs.sessionCookie(t, testShop))
+	if got := rec.Header().Get("Content-Security-Policy"); strings.Contains(got, "evil-store") {
+		t.Fatalf("csp must follow the verified shop, got %q", got)
+	}
+}
+
+func TestLogin_EmbeddedExitsIframe(t *testing.T) {
+	hs := newHarness(t)
+
+	v := url.Values{}
+	v.Set("shop", testShop)
+	v.Set("embedded", "1")
+	v.Set("timestamp", "1735732800")
+	rec := hs.get("/login?" + signedQuery(v, hs.cfg.Apps[0].APISecret))
+	assertStatus(t, rec, http.StatusOK)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "app-bridge.js") || !strings.Contains(body, `"_top"`) {
+		t.Fatalf("expected App Bridge top-level redirect page: %s", body)
+	}
+	if !strings.Contains(body, `window.open("https://app.example.com/login?shop=`+testShop) {
+		t.Fatalf("exit iframe must resume /login at the top level: %s", body)
+	}
+	if len(hs.states.states) != 0 {
+		t.Fatalf("oauth state must be created by the top-level request")
+	}
+
+	// an installed shop opened from the Admin stays in the iframe
+	hs.install(testShop)
+	rec = hs.get("/login?" + signedQuery(v, hs.cfg.Apps[0].APISecret))
+	assertStatus(t, rec, http.StatusFound)
+	if !strings.HasPrefix(rec.Header().Get("Location"), "/dashboard") {
+		t.Fatalf("expected dashboard redirect, got %s", rec.Header().Get("Location"))
+	}
+}
+
+func TestOAuthCallback_EmbeddedReturnsToAdmin(t *testing.T) {
+	hs := newHarness(t)
+	hs.cfg.Apps[0].Embedded = true
+	hs.build(hs.tokens)
+
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	rec := hs.get("/auth/callback?" + hs.callbackQuery(testShop, hs.states.onlyNonce(t)))
+	assertStatus(t, rec, http.StatusFound)
+
+	want := "https://" + testShop + "/admin/apps/" + hs.cfg.Apps[0].APIKey
+	if got := rec.Header().Get("Location"); got != want {
+		t.Fatalf("redirect = %s, want %s", got, want)
+	}
+}
+
+func TestLogin_HostCarriedThroughState(t *testing.T) {
+	hs := newHarness(t)
+	hs.cfg.Apps[0].Embedded = true
+	hs.build(hs.tokens)
+
+	host := base64.StdEncoding.EncodeToString([]byte("admin.shopify.com/store/test-store"))
+
+	// host for another store is rejected
+	other := base64.StdEncoding.EncodeToString([]byte("admin.shopify.com/store/other-store"))
+	assertStatus(t, hs.get("/login?shop="+testShop+"&host="+url.QueryEscape(other)), http.StatusBadRequest)
+
+	// exit iframe keeps host for the top-level request
+	rec := hs.get("/login?shop=" + testShop + "&embedded=1&host=" + url.QueryEscape(host))
+	assertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "host="+url.QueryEscape(host)) {
+		t.Fatalf("exit iframe dropped host: %s", rec.Body.String())
+	}
+
+	assertStatus(t, hs.get("/login?shop="+testShop+"&host="+url.QueryEscape(host)), http.StatusFound)
+	rec = hs.get("/auth/callback?" + hs.callbackQuery(testShop, hs.states.onlyNonce(t)))
+	assertStatus(t, rec, http.StatusFound)
+
+	want := "https://admin.shopify.com/store/test-store/apps/" + hs.cfg.Apps[0].APIKey
+	if got := rec.Header().Get("Location"); got != want {
+		t.Fatalf("redirect = %s, want %s", got, want)
+	}
+}
+
+func TestDashboard_EscapesShopData(t *testing.T) {
+	hs := newHarness(t)
+	_, _ = hs.shops.Install(context.Background(), testAPIKey, testShop, "shpat_existing", `read_products,<script>alert(1)</script>`)
+
+	rec := hs.get("/dashboard?shop="+testShop, hs.sessionCookie(t, testShop))
+	assertStatus(t, rec, http.StatusOK)
+
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>alert(1)</script>") {
+		t.Fatalf("scopes rendered unescaped: %s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Fatalf("expected escaped scope in page: %s", body)
+	}
+}
+
+func TestMerchantErrorsRenderHTML(t *testing.T) {
+	hs := newHarness(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/login?shop=evil.com", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+
+	assertStatus(t, rec, http.StatusBadRequest)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("content type = %q, want text/html", ct)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "<title>Bad Request</title>") || !strings.Contains(body, "invalid shop domain") {
+		t.Fatalf("unexpected error page: %s", body)
+	}
+	if !strings.Contains(body, "invalid_shop") || !strings.Contains(body, rec.Header().Get(requestIDHeader)) {
+		t.Fatalf("error page must show the code and request id: %s", body)
+	}
+}
+
+func TestErrorsAreProblemJSON(t *testing.T) {
+	cases := []struct {
+		name   string
+		setup  func(hs *harness) *httptest.ResponseRecorder
+		status int
+		code   apperr.Code
+	}{
+		{"missing shop", func(hs *harness) *httptest.ResponseRecorder { return hs.get("/login") }, http.StatusBadRequest, apperr.CodeMissingParameter},
+		{"invalid hmac", func(hs *harness) *httptest.ResponseRecorder {
+			return hs.get("/login?shop=" + testShop + "&hmac=deadbeef&timestamp=1")
+		}, http.StatusUnauthorized, apperr.CodeInvalidHMAC},
+		{"expired state", func(hs *harness) *httptest.ResponseRecorder {
+			return hs.get("/auth/callback?" + hs.callbackQuery(testShop, "unknown-nonce"))
+		}, http.StatusUnauthorized, apperr.CodeStateExpired},
+		{"not installed", func(hs *harness) *httptest.ResponseRecorder {
+			return hs.get("/dashboard?shop="+testShop, hs.sessionCookie(t, testShop))
+		}, http.StatusNotFound, apperr.CodeShopNotInstalled},
+		{"database error", func(hs *harness) *httptest.ResponseRecorder {
+			hs.shops.getErr = errDB
+			return hs.get("/login?shop=" + testShop)
+		}, http.StatusInternalServerError, apperr.CodeDatabase},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hs := newHarness(t)
+			rec := tc.setup(hs)
+			assertStatus(t, rec, tc.status)
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Fatalf("content type = %q, want application/problem+json", ct)
+			}
+			var p apperr.Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatalf("decode problem: %v", err)
+			}
+			if p.Code != tc.code || p.Status != tc.status || p.Title != http.StatusText(tc.status) {
+				t.Fatalf("unexpected problem %+v", p)
+			}
+			if p.RequestID == "" || p.RequestID != rec.Header().Get(requestIDHeader) {
+				t.Fatalf("problem request id %q does not match header %q", p.RequestID, rec.Header().Get(requestIDHeader))
+			}
+			if strings.Contains(rec.Body.String(), errDB.Error()) {
+				t.Fatalf("internal cause leaked to client: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// logLines decodes the JSON log records written so far
+func (hs *harness) logLines(t *testing.T) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(hs.logs.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			t.Fatalf("decode log line %q: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestRequestID(t *testing.T) {
+	hs := newHarness(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(requestIDHeader, "edge-1234")
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got != "edge-1234" {
+		t.Fatalf("incoming request id not kept, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(requestIDHeader, "bad id\nwith newline")
+	rec = httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got == "" || strings.ContainsAny(got, " \n") {
+		t.Fatalf("malformed request id must be replaced, got %q", got)
+	}
+}
+
+func TestAccessLogSharesRequestID(t *testing.T) {
+	hs := newHarness(t)
+	hs.shops.getErr = errDB
+
+	q := url.Values{}
+	q.Set("shop", testShop)
+	q.Set("timestamp", "1700000000")
+	rec := hs.get("/login?" + signedQuery(q, hs.cfg.Apps[0].APISecret))
+	assertStatus(t, rec, http.StatusInternalServerError)
+	id := rec.Header().Get(requestIDHeader)
+
+	var access, failure map[string]any
+	for _, l := range hs.logLines(t) {
+		if l["request_id"] != id {
+			t.Fatalf("log line without the request id: %v", l)
+		}
+		switch l["msg"] {
+		case "request":
+			access = l
+		case "database error":
+			failure = l
+		}
+	}
+	if failure == nil || failure["code"] != string(apperr.CodeDatabase) {
+		t.Fatalf("missing error log, got %s", hs.logs.String())
+	}
+	if access == nil {
+		t.Fatalf("missing access log, got %s", hs.logs.String())
+	}
+	if access["route"] != "/login" || access["status"] != float64(http.StatusInternalServerError) || access["shop"] != testShop || access["ip"] == "" {
+		t.Fatalf("unexpected access log %v", access)
+	}
+}
+
+func TestLogsNeverContainSecrets(t *testing.T) {
+	hs := newHarness(t)
+	hs.install("installed-store.myshopify.com")
+
+	// fresh install, nonce persisted but the callback fails at every stage once
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	nonce := hs.states.onlyNonce(t)
+	cbQuery := hs.callbackQuery(testShop, nonce)
+	cb, _ := url.ParseQuery(cbQuery)
+
+	hs.states.consumeErr = errDB
+	assertStatus(t, hs.get("/auth/callback?"+cbQuery), http.StatusInternalServerError)
+	hs.states.consumeErr = nil
+
+	hs.tokens.err = errors.New(`shopify returned status 400: {"code":"auth-code","client_secret":"test-api-secret","access_token":"shpat_leaked"}`)
+	assertStatus(t, hs.get("/auth/callback?"+cbQuery), http.StatusBadGateway)
+	hs.tokens.err = nil
+
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	nonce2 := hs.states.onlyNonce(t)
+	rec := hs.get("/auth/callback?" + hs.callbackQuery(testShop, nonce2))
+	assertStatus(t, rec, http.StatusFound)
+	cookie := findCookie(rec, sessionCookieName)
+
+	// state persistence failure and a signed login for an installed shop
+	hs.states.createErr = errDB
+	assertStatus(t, hs.get("/login?shop=other-store.myshopify.com"), http.StatusInternalServerError)
+	q := url.Values{}
+	q.Set("shop", "installed-store.myshopify.com")
+	q.Set("timestamp", "1700000000")
+	assertStatus(t, hs.get("/login?"+signedQuery(q, hs.cfg.Apps[0].APISecret)), http.StatusFound)
+
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusOK)
+	hs.sessions.getErr = errDB
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusInternalServerError)
+
+	logs := hs.logs.String()
+	if logs == "" {
+		t.Fatalf("expected debug logs to be written")
+	}
+	for name, secret := range map[string]string{
+		"nonce":          nonce,
+		"second nonce":   nonce2,
+		"code":           "auth-code",
+		"hmac":           cb.Get("hmac"),
+		"access token":   "shpat_leaked",
+		"stored token":   "shpat_test",
+		"api secret":     hs.cfg.Apps[0].APISecret,
+		"session cookie": cookie.Value,
+	} {
+		if strings.Contains(logs, secret) {
+			t.Fatalf("%s leaked into logs:\n%s", name, logs)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	hs := newHarness(t)
+
+	// an installed shop with a signed login, a started OAuth flow and a rejected callback
+	hs.install("installed-store.myshopify.com")
+	q := url.Values{}
+	q.Set("shop", "installed-store.myshopify.com")
+	q.Set("timestamp", "1700000000")
+	assertStatus(t, hs.get("/login?"+signedQuery(q, hs.cfg.Apps[0].APISecret)), http.StatusFound)
+	assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery(testShop, "unknown-nonce")), http.StatusUnauthorized)
+
+	nonce := hs.states.onlyNonce(t)
+	assertStatus(t, hs.get("/auth/callback?"+hs.callbackQuery(testShop, nonce)), http.StatusFound)
+
+	// loopback is not configured in the harness, the remote address is 192.0.2.1
+	assertStatus(t, hs.get("/metrics"), http.StatusForbidden)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusOK)
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`shopify_auth_login_starts_total{flow="authorize"} 1`,
+		`shopify_auth_login_starts_total{flow="session"} 1`,
+		`shopify_auth_oauth_callbacks_total{outcome="state_expired"} 1`,
+		`shopify_auth_oauth_callbacks_total{outcome="success"} 1`,
+		`shopify_auth_token_exchange_duration_seconds_count{result="success"} 1`,
+		`shopify_auth_http_request_duration_seconds_count{method="GET",route="/login",status="302"} 2`,
+		`shopify_auth_http_request_duration_seconds_count{method="GET",route="/metrics",status="403"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsAllowedCIDR(t *testing.T) {
+	hs := newHarness(t)
+	hs.cfg.MetricsToken = ""
+	hs.cfg.MetricsAllowedCIDRs = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	hs.build(hs.tokens)
+
+	assertStatus(t, hs.get("/metrics"), http.StatusOK)
+
+	hs.cfg.MetricsEnabled = false
+	hs.build(hs.tokens)
+	assertStatus(t, hs.get("/metrics"), http.StatusNotFound)
+}
+
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	rec := telemetrytest.RecordSpans()
+	hs := newHarness(t)
+	hs.install(testShop)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	q := url.Values{}
+	q.Set("shop", testShop)
+	q.Set("timestamp", "1700000000")
+	req := httptest.NewRequest(http.MethodGet, "/login?"+signedQuery(q, hs.cfg.Apps[0].APISecret), nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	res := httptest.NewRecorder()
+	hs.router.ServeHTTP(res, req)
+	assertStatus(t, res, http.StatusFound)
+
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID().String() != traceID {
+			continue
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range s.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		if s.Name() != "GET /login" || s.SpanKind() != trace.SpanKindServer {
+			t.Fatalf("unexpected span %q kind %v", s.Name(), s.SpanKind())
+		}
+		if attrs["http.route"].AsString() != "/login" || attrs["http.response.status_code"].AsInt64() != http.StatusFound {
+			t.Fatalf("unexpected attributes %v", attrs)
+		}
+		if attrs[telemetry.ShopDomainKey].AsString() != testShop {
+			t.Fatalf("verified shop missing from span: %v", attrs)
+		}
+
+		var access map[string]any
+		for _, l := range hs.logLines(t) {
+			if l["msg"] == "request" {
+				access = l
+			}
+		}
+		if access["trace_id"] != traceID {
+			t.Fatalf("access log not correlated with the trace: %v", access)
+		}
+		return
+	}
+	t.Fatalf("no server span recorded for trace %s", traceID)
+}
+
+func TestLivezAndReadyz(t *testing.T) {
+	hs := newHarness(t)
+	dbUp := true
+	hs.ready.Add("postgres", func(ctx context.Context) error {
+		if !dbUp {
+			return errDB
+		}
+		return nil
+	})
+
+	assertStatus(t, hs.get("/livez"), http.StatusOK)
+
+	readyz := func(want int) health.Report {
+		t.Helper()
+		rec := hs.get("/readyz")
+		assertStatus(t, rec, want)
+		var r health.Report
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("decode report: %v", err)
+		}
+		return r
+	}
+
+	if r := readyz(http.StatusOK); r.Checks["postgres"].Status != health.StatusOK {
+		t.Fatalf("unexpected report %+v", r)
+	}
+
+	dbUp = false
+	r := readyz(http.StatusServiceUnavailable)
+	if r.Checks["postgres"].Status != health.StatusFail {
+		t.Fatalf("failing check not reported: %+v", r)
+	}
+	// the error stays in the server log
+	if rec := hs.get("/readyz"); strings.Contains(rec.Body.String(), errDB.Error()) {
+		t.Fatalf("readiness body leaks the check error: %s", rec.Body.String())
+	}
+	logged := false
+	for _, line := range hs.logLines(t) {
+		if line["msg"] == "readiness check failed" && line["check"] == "postgres" && line["err"] == errDB.Error() {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Fatalf("failing check not logged: %s", hs.logs.String())
+	}
+	// liveness does not depend on the database
+	assertStatus(t, hs.get("/livez"), http.StatusOK)
+
+	dbUp = true
+	hs.ready.Shutdown()
+	if r := readyz(http.StatusServiceUnavailable); r.Checks["shutdown"].Status != health.StatusFail {
+		t.Fatalf("readiness must fail once shutdown started: %+v", r)
+	}
+}
+
+// signedLogin is the /login URL of an Admin launch for shop
+func (hs *harness) signedLogin(shop string) string {
+	v := url.Values{}
+	v.Set("shop", shop)
+	v.Set("timestamp", "1735732800")
+	return "/login?" + signedQuery(v, hs.cfg.Apps[0].APISecret)
+}
+
+func TestRateLimit(t *testing.T) {
+	hs := newHarness(t)
+	hs.cfg.RateLimitIP = ratelimit.Limit{Burst: 4, Period: time.Hour}
+	hs.cfg.RateLimitShop = ratelimit.Limit{Burst: 2, Period: time.Hour}
+	hs.limiter = ratelimit.NewMemory()
+	hs.build(hs.tokens)
+
+	for i := 0; i < 2; i++ {
+		assertStatus(t, hs.get(hs.signedLogin(testShop)), http.StatusFound)
+	}
+	// the shop bucket is empty, a different shop still has the ip budget
+	rec := hs.get(hs.signedLogin(testShop))
+	assertStatus(t, rec, http.StatusTooManyRequests)
+	if ra := rec.Header().Get("Retry-After"); ra != "1800" {
+		t.Fatalf("unexpected Retry-After %q", ra)
+	}
+	var p apperr.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || p.Code != apperr.CodeRateLimited {
+		t.Fatalf("unexpected problem %s", rec.Body.String())
+	}
+	assertStatus(t, hs.get(hs.signedLogin("other-shop.myshopify.com")), http.StatusFound)
+
+	// the ip bucket is empty now, denied requests took their ip token too
+	assertStatus(t, hs.get(hs.signedLogin("third-shop.myshopify.com")), http.StatusTooManyRequests)
+	// buckets are per route
+	assertStatus(t, hs.get("/auth/callback"), http.StatusBadRequest)
+}
+
+// only Shopify-signed requests spend a shop's budget, anyone can name a shop
+func TestRateLimitShopNeedsVerifiedRequests(t *testing.T) {
+	hs := newHarness(t)
+	hs.cfg.RateLimitIP = ratelimit.Limit{Burst: 100, Period: time.Hour}
+	hs.cfg.RateLimitShop = ratelimit.Limit{Burst: 2, Period: time.Hour}
+	hs.limiter = ratelimit.NewMemory()
+	hs.build(hs.tokens)
+
+	forged := url.Values{}
+	forged.Set("shop", testShop)
+	forged.Set("code", "auth-code")
+	forged.Set("state", "guess")
+	forged.Set("timestamp", "1735732800")
+	forged.Set("hmac", "00")
+	for i := 0; i < 5; i++ {
+		assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+		assertStatus(t, hs.get("/login?"+forged.Encode()), http.StatusUnauthorized)
+		assertStatus(t, hs.get("/auth/callback?"+forged.Encode()), http.StatusUnauthorized)
+	}
+
+	// the merchant still installs, from a single stored state
+	hs.states.states = map[string]memState{}
+	assertStatus(t, hs.oauthInstall(t, testShop), http.StatusFound)
+	assertStatus(t, hs.get(hs.signedLogin(testShop)), http.StatusFound)
+}
+
+func TestRateLimitTrustsOnlyConfiguredProxies(t *testing.T) {
+	hs := newHarness(t)
+	hs.cfg.RateLimitIP = ratelimit.Limit{Burst: 1, Period: time.Hour}
+	hs.cfg.RateLimitShop = ratelimit.Limit{Burst: 100, Period: time.Hour}
+	hs.limiter = ratelimit.NewMemory()
+	hs.build(hs.tokens)
+
+	login := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/login?shop="+testShop, nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		hs.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// httptest requests come from 192.0.2.1, which is not trusted: the header is ignored
+	if login("203.0.113.1") != http.StatusFound || login("203.0.113.2") != http.StatusTooManyRequests {
+		t.Fatal("untrusted peer was able to choose its rate limit bucket")
+	}
+
+	hs.cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	hs.build(hs.tokens)
+	if login("203.0.113.1") != http.StatusFound || login("203.0.113.2") != http.StatusFound {
+		t.Fatal("clients behind a trusted proxy must get their own bucket")
+	}
+	if login("203.0.113.1") != http.StatusTooManyRequests {
+		t.Fatal("forwarded client was not limited")
+	}
+}
+
+// addApp serves a second app named partner next to the default one
+func (hs *harness) addApp() config.App {
+	app := config.App{
+		Name:        "partner",
+		APIKey:      "partner-key",
+		APISecret:   "partner-secret",
+		Scopes:      "read_orders",
+		CallbackURL: "https://app.example.com/apps/partner/auth/callback",
+	}
+	hs.cfg.Apps = append(hs.cfg.Apps, app)
+	hs.build(hs.tokens)
+	return app
+}
+
+func TestMultipleApps_Install(t *testing.T) {
+	hs := newHarness(t)
+	partner := hs.addApp()
+
+	rec := hs.get("/apps/unknown/login?shop=" + testShop)
+	assertStatus(t, rec, http.StatusNotFound)
+	if !strings.Contains(rec.Body.String(), string(apperr.CodeUnknownApp)) {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+
+	rec = hs.get("/apps/partner/login?shop=" + testShop)
+	assertStatus(t, rec, http.StatusFound)
+	authURL, _ := url.Parse(rec.Header().Get("Location"))
+	q := authURL.Query()
+	if q.Get("client_id") != partner.APIKey || q.Get("redirect_uri") != partner.CallbackURL || q.Get("scope") != partner.Scopes {
+		t.Fatalf("authorize url not built for the partner app: %s", authURL)
+	}
+	nonce := hs.states.onlyNonce(t)
+
+	callback := url.Values{}
+	callback.Set("shop", testShop)
+	callback.Set("code", "auth-code")
+	callback.Set("state", nonce)
+	callback.Set("timestamp", "1735732800")
+
+	// the default app neither accepts the partner's signature nor consumes the partner's state
+	assertStatus(t, hs.get("/auth/callback?"+signedQuery(callback, partner.APISecret)), http.StatusUnauthorized)
+	rec = hs.get("/auth/callback?" + signedQuery(callback, hs.cfg.Apps[0].APISecret))
+	assertStatus(t, rec, http.StatusUnauthorized)
+	if !strings.Contains(rec.Body.String(), string(apperr.CodeStateExpired)) {
+		t.Fatalf("expected state_expired, got %s", rec.Body.String())
+	}
+
+	rec = hs.get("/apps/partner/auth/callback?" + signedQuery(callback, partner.APISecret))
+	assertStatus(t, rec, http.StatusFound)
+	if loc := rec.Header().Get("Location"); loc != "/apps/partner/dashboard?shop="+testShop {
+		t.Fatalf("unexpected redirect %q", loc)
+	}
+	if hs.tokens.app.APIKey != partner.APIKey || hs.tokens.app.APISecret != partner.APISecret {
+		t.Fatalf("token exchanged with the wrong credentials: %+v", hs.tokens.app)
+	}
+	if _, err := hs.shops.GetByDomain(context.Background(), partner.APIKey, testShop); err != nil {
+		t.Fatalf("partner installation not stored: %v", err)
+	}
+	if _, err := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); err != repository.ErrNotFound {
+		t.Fatalf("default app must stay uninstalled, got %v", err)
+	}
+
+	cookie := findCookie(rec, sessionCookieName+"_partner")
+	if cookie == nil {
+		t.Fatal("partner session cookie not set")
+	}
+	assertStatus(t, hs.get("/apps/partner/dashboard?shop="+testShop, cookie), http.StatusOK)
+	// the app is also reachable by api key
+	assertStatus(t, hs.get("/apps/partner-key/dashboard?shop="+testShop, cookie), http.StatusOK)
+
+	// a partner session does not open the default app's dashboard
+	hs.install(testShop)
+	stolen := &http.Cookie{Name: sessionCookieName, Value: cookie.Value}
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, stolen), http.StatusUnauthorized)
+}
+
+func TestMultipleApps_UninstallOnlyTouchesOneApp(t *testing.T) {
+	hs := newHarness(t)
+	partner := hs.addApp()
+	hs.install(testShop)
+	_, _ = hs.shops.Install(context.Background(), partner.APIKey, testShop, "shpat_partner", "read_orders")
+
+	fake := shopifytest.NewServerWithCredentials(partner.APIKey, partner.APISecret)
+	defer fake.Close()
+
+	deliver := func(target string) int {
+		req, err := fake.NewWebhookRequest(target, "app/uninstalled", testShop, map[string]any{"domain": testShop})
+		if err != nil {
+			t.Fatalf("new webhook: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		hs.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := deliver("/webhooks/app/uninstalled"); code != http.StatusUnauthorized {
+		t.Fatalf("default app accepted a partner signature: %d", code)
+	}
+	if code := deliver("/apps/partner/webhooks/app/uninstalled"); code != http.StatusOK {
+		t.Fatalf("partner uninstall failed: %d", code)
+	}
+
+	if s, err := hs.shops.GetByDomain(context.Background(), partner.APIKey, testShop); err != nil || s.State != repository.StateUninstalled {
+		t.Fatalf("partner installation not uninstalled: %+v, %v", s, err)
+	}
+	if s, err := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); err != nil || s.State != repository.StateActive {
+		t.Fatalf("default installation must survive: %+v, %v", s, err)
+	}
+}
+
+func (hs *harness) scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusOK)
+	return rec.Body.String()
+}
+
+func (hs *harness) apiShop(token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/shop", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestSecretRotation signs every kind of request with the old secret while Shopify rotates it
+func TestSecretRotation(t *testing.T) {
+	const newSecret = "rotated-api-secret"
+	old := shopifytest.NewServer()
+	defer old.Close()
+	rotated := shopifytest.NewServerWithCredentials(testAPIKey, newSecret)
+	defer rotated.Close()
+
+	hs := newHarness(t)
+	hs.cfg.Apps[0].APISecret = newSecret
+	hs.cfg.Apps[0].APISecretSecondary = old.APISecret
+	hs.build(hs.tokens)
+	hs.install(testShop)
+
+	launch := url.Values{}
+	launch.Set("shop", testShop)
+	assertStatus(t, hs.get("/login?"+old.SignedQuery(launch).Encode()), http.StatusFound)
+	assertStatus(t, hs.get("/login?"+rotated.SignedQuery(launch).Encode()), http.StatusFound)
+
+	req, err := old.NewWebhookRequest("/webhooks/app/scopes_update", "app/scopes_update", testShop,
+		map[string]any{"current": []string{"read_products"}})
+	if err != nil {
+		t.Fatalf("new webhook: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusOK)
+
+	assertStatus(t, hs.apiShop(old.SessionToken(testShop, hs.clock.Now())), http.StatusOK)
+
+	proxy := url.Values{}
+	proxy.Set("shop", testShop)
+	proxy.Set("path_prefix", "/apps/reviews")
+	proxy.Set("timestamp", strconv.FormatInt(hs.clock.Now().Unix(), 10))
+	assertStatus(t, hs.get("/proxy/reviews?"+old.SignProxyQuery(proxy).Encode()), http.StatusOK)
+
+	body := hs.scrapeMetrics(t)
+	for _, want := range []string{
+		// SecurityHeaders and Login both check the launch query, it is counted once per request
+		`shopify_auth_secret_matches_total{app="default",secret="primary",verifier="query_hmac"} 1`,
+		`shopify_auth_secret_matches_total{app="default",secret="secondary",verifier="query_hmac"} 1`,
+		`shopify_auth_secret_matches_total{app="default",secret="secondary",verifier="webhook_hmac"} 1`,
+		`shopify_auth_secret_matches_total{app="default",secret="secondary",verifier="session_token"} 1`,
+		`shopify_auth_secret_matches_total{app="default",secret="secondary",verifier="app_proxy"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	// once the old secret is dropped its signatures are rejected
+	hs.cfg.Apps[0].APISecretSecondary = ""
+	hs.build(hs.tokens)
+	assertStatus(t, hs.get("/login?"+old.SignedQuery(launch).Encode()), http.StatusUnauthorized)
+	assertStatus(t, hs.apiShop(old.SessionToken(testShop, hs.clock.Now())), http.StatusUnauthorized)
+	assertStatus(t, hs.get("/proxy/reviews?"+old.SignProxyQuery(proxy).Encode()), http.StatusUnauthorized)
+
+	// a captured proxy URL cannot be replayed once its timestamp is stale
+	captured := "/proxy/reviews?" + rotated.SignProxyQuery(proxy).Encode()
+	assertStatus(t, hs.get(captured), http.StatusOK)
+	hs.clock.Advance(time.Minute)
+	assertStatus(t, hs.get(captured), http.StatusUnauthorized)
+}
+
+func TestAPIShop(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+	hs := newHarness(t)
+
+	rec := hs.apiShop("")
+	assertStatus(t, rec, http.StatusUnauthorized)
+	if !strings.Contains(rec.Body.String(), string(apperr.CodeInvalidSessionToken)) {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+
+	expired := fake.SessionToken(testShop, hs.clock.Now().Add(-time.Hour))
+	assertStatus(t, hs.apiShop(expired), http.StatusUnauthorized)
+
+	token := fake.SessionToken(testShop, hs.clock.Now())
+	assertStatus(t, hs.apiShop(token), http.StatusNotFound)
+
+	hs.install(testShop)
+	rec = hs.apiShop(token)
+	assertStatus(t, rec, http.StatusOK)
+	var got struct {
+		Shop   string   `json:"shop"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.Shop != testShop || len(got.Scopes) != 1 {
+		t.Fatalf("unexpected body %s (%v)", rec.Body.String(), err)
+	}
+	// the token proves the shop, so the app may be framed by its admin
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "https://"+testShop) {
+		t.Fatalf("unexpected CSP %q", csp)
+	}
+
+	// a token of another app is rejected
+	other := shopifytest.NewServerWithCredentials("other-key", shopifytest.DefaultAPISecret)
+	defer other.Close()
+	assertStatus(t, hs.apiShop(other.SessionToken(testShop, hs.clock.Now())), http.StatusUnauthorized)
+}
+
+// webhook delivers a webhook signed by fake and returns the response
+func (hs *harness) webhook(t *testing.T, fake *shopifytest.Server, target, topic string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := fake.NewWebhookRequest(target, topic, testShop, payload)
+	if err != nil {
+		t.Fatalf("new webhook: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// oauthInstall runs Login and OAuthCallback for shop
+func (hs *harness) oauthInstall(t *testing.T, shop string) *httptest.ResponseRecorder {
+	t.Helper()
+	assertStatus(t, hs.get("/login?shop="+shop), http.StatusFound)
+	return hs.get("/auth/callback?" + hs.callbackQuery(shop, hs.states.onlyNonce(t)))
+}
+
+func TestShopLifecycle_Reinstall(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+	hs := newHarness(t)
+
+	assertStatus(t, hs.oauthInstall(t, testShop), http.StatusFound)
+	first, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if first.State != repository.StateActive || first.ReinstallCount != 0 {
+		t.Fatalf("unexpected first install %+v", first)
+	}
+
+	hs.clock.Advance(time.Hour)
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/uninstalled", "app/uninstalled", map[string]any{"domain": testShop}), http.StatusOK)
+	cookie := hs.sessionCookie(t, testShop)
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, cookie), http.StatusNotFound)
+
+	hs.clock.Advance(time.Hour)
+	rec := hs.oauthInstall(t, testShop)
+	assertStatus(t, rec, http.StatusFound)
+	s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if s.State != repository.StateActive || s.OfflineAccessToken != "shpat_test" {
+		t.Fatalf("reinstall did not activate the shop: %+v", s)
+	}
+	if !s.FirstInstalledAt.Equal(first.FirstInstalledAt) || !s.InstalledAt.After(first.InstalledAt) || s.ReinstallCount != 1 {
+		t.Fatalf("reinstall bookkeeping wrong: first %+v, now %+v", first, s)
+	}
+	assertStatus(t, hs.get("/dashboard?shop="+testShop, findCookie(rec, sessionCookieName)), http.StatusOK)
+}
+
+func TestShopLifecycle_FrozenAndNeedsReauth(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+	hs := newHarness(t)
+	hs.install(testShop)
+	cookie := hs.sessionCookie(t, testShop)
+	token := fake.SessionToken(testShop, hs.clock.Now())
+
+	freeze := map[string]any{"name": "Store", "plan_name": "frozen", "plan_display_name": "Frozen"}
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/shop/update", "shop/update", freeze), http.StatusOK)
+	if s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); s.State != repository.StateFrozen {
+		t.Fatalf("shop not frozen: %+v", s)
+	}
+
+	rec := hs.get("/dashboard?shop="+testShop, cookie)
+	assertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "frozen until its Shopify bill is paid") {
+		t.Fatalf("dashboard does not say the shop is frozen: %s", rec.Body.String())
+	}
+	rec = hs.apiShop(token)
+	assertStatus(t, rec, http.StatusPaymentRequired)
+	if !strings.Contains(rec.Body.String(), string(apperr.CodeShopFrozen)) {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+
+	// a reinstall while frozen keeps the shop frozen
+	assertStatus(t, hs.oauthInstall(t, testShop), http.StatusFound)
+	if s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); s.State != repository.StateFrozen {
+		t.Fatalf("install unfroze the shop: %+v", s)
+	}
+
+	unfreeze := map[string]any{"name": "Store", "plan_name": "basic", "plan_display_name": "Basic"}
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/shop/update", "shop/update", unfreeze), http.StatusOK)
+	assertStatus(t, hs.apiShop(token), http.StatusOK)
+
+	if _, err := hs.shops.Transition(context.Background(), testAPIKey, testShop, repository.StateNeedsReauth); err != nil {
+		t.Fatalf("transition: %v", err)
+	}
+	rec = hs.get("/dashboard?shop="+testShop, cookie)
+	assertStatus(t, rec, http.StatusFound)
+	if loc := rec.Header().Get("Location"); loc != "/login?shop="+testShop {
+		t.Fatalf("unexpected redirect %q", loc)
+	}
+	assertStatus(t, hs.apiShop(token), http.StatusUnauthorized)
+
+	// needs_reauth has no working token, the login fast path is skipped
+	launch := url.Values{}
+	launch.Set("shop", testShop)
+	rec = hs.get("/login?" + fake.SignedQuery(launch).Encode())
+	assertStatus(t, rec, http.StatusFound)
+	if loc := rec.Header().Get("Location"); !strings.Contains(loc, "/admin/oauth/authorize") {
+		t.Fatalf("expected OAuth redirect, got %q", loc)
+	}
+	rec = hs.get("/auth/callback?" + hs.callbackQuery(testShop, hs.states.onlyNonce(t)))
+	assertStatus(t, rec, http.StatusFound)
+	if s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); s.State != repository.StateActive || s.ReinstallCount != 0 {
+		t.Fatalf("re-authorization did not reactivate the shop: %+v", s)
+	}
+}
+
+// "state" is a redacted log key, lifecycle diagnostics must log the shop state under other keys
+func TestShopStateLogged(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+	hs := newHarness(t)
+	hs.install(testShop)
+	if _, err := hs.shops.Transition(context.Background(), testAPIKey, testShop, repository.StateFrozen); err != nil {
+		t.Fatalf("transition: %v", err)
+	}
+	assertStatus(t, hs.apiShop(fake.SessionToken(testShop, hs.clock.Now())), http.StatusPaymentRequired)
+
+	hs.shops.upsertErr = errDB
+	uninstall := map[string]any{"id": 1, "domain": testShop}
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/uninstalled", "app/uninstalled", uninstall), http.StatusInternalServerError)
+
+	want := map[string]string{"shop_state": string(repository.StateFrozen), "to_state": string(repository.StateUninstalled)}
+	for _, line := range hs.logLines(t) {
+		for key, value := range want {
+			if line[key] == value {
+				delete(want, key)
+			}
+		}
+	}
+	if len(want) > 0 {
+		t.Fatalf("missing state attributes %v in logs %s", want, hs.logs.String())
+	}
+}
+
+func TestShopRedactWebhook(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+	hs := newHarness(t)
+	hs.install(testShop)
+	_ = hs.shops.UpdateMetadata(context.Background(), testAPIKey, testShop, repository.ShopMetadata{Name: "Store", Email: "owner@example.com"})
+
+	redact := map[string]any{"shop_id": 1, "shop_domain": testShop}
+	// an installed shop is not redacted, the late delivery is acknowledged
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/shop/redact", "shop/redact", redact), http.StatusOK)
+	if s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); s.State != repository.StateActive || s.Email == "" {
+		t.Fatalf("active shop must not be redacted: %+v", s)
+	}
+
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/uninstalled", "app/uninstalled", map[string]any{"domain": testShop}), http.StatusOK)
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/shop/redact", "shop/redact", redact), http.StatusOK)
+	s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop)
+	if s.State != repository.StateRedacted || s.Email != "" || s.Name != "" || s.Scopes != "" || s.MetadataSyncedAt != nil {
+		t.Fatalf("shop data not erased: %+v", s)
+	}
+
+	events, _ := hs.shops.Timeline(context.Background(), testAPIKey, testShop, 0, repository.MaxTimeline)
+	for _, e := range events {
+		if e.IP != "" || len(e.Metadata) != 0 {
+			t.Fatalf("redacted shop keeps event data: %+v", e)
+		}
+	}
+
+	// a shop/update for a redacted shop does not bring its data back
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/shop/update", "shop/update", map[string]any{"email": "owner@example.com"}), http.StatusOK)
+	if s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); s.Email != "" {
+		t.Fatalf("redacted shop updated: %+v", s)
+	}
+
+	// nor does a late app/scopes_update, which is acknowledged without a new event
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/scopes_update", "app/scopes_update",
+		map[string]any{"previous": []string{"read_products"}, "current": []string{"read_products", "read_orders"}}), http.StatusOK)
+	if s, _ := hs.shops.GetByDomain(context.Background(), testAPIKey, testShop); s.Scopes != "" {
+		t.Fatalf("redacted shop got scopes back: %+v", s)
+	}
+	if after, _ := hs.shops.Timeline(context.Background(), testAPIKey, testShop, 0, repository.MaxTimeline); len(after) != len(events) {
+		t.Fatalf("scopes update recorded an event on a redacted shop: %+v", after)
+	}
+}
+
+func TestShopEvents(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+	hs := newHarness(t)
+
+	assertStatus(t, hs.oauthInstall(t, testShop), http.StatusFound)
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/scopes_update", "app/scopes_update",
+		map[string]any{"current": []string{"read_products", "read_orders"}}), http.StatusOK)
+	// unchanged scopes are not an event
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/scopes_update", "app/scopes_update",
+		map[string]any{"current": []string{"read_products", "read_orders"}}), http.StatusOK)
+	hs.clock.Advance(time.Hour)
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/app/uninstalled", "app/uninstalled", map[string]any{"domain": testShop}), http.StatusOK)
+	hs.clock.Advance(time.Hour)
+	assertStatus(t, hs.oauthInstall(t, testShop), http.StatusFound)
+	assertStatus(t, hs.webhook(t, fake, "/webhooks/shop/update", "shop/update", map[string]any{"plan_name": "frozen"}), http.StatusOK)
+
+	want := []repository.EventType{
+		repository.EventInstallStarted, repository.EventInstalled, repository.EventScopesChanged,
+		repository.EventUninstalled, repository.EventInstallStarted, repository.EventReinstalled, repository.EventFrozen,
+	}
+	if got := hs.shops.eventTypes(testShop); !slices.Equal(got, want) {
+		t.Fatalf("unexpected history %v, want %v", got, want)
+	}
+
+	token := fake.SessionToken(testShop, hs.clock.Now())
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/shop/events"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		hs.router.ServeHTTP(rec, req)
+		return rec
+	}
+	type page struct {
+		Events []struct {
+			ID       int64          `json:"id"`
+			Type     string         `json:"type"`
+			Actor    string         `json:"actor"`
+			IP       string         `json:"ip"`
+			Metadata map[string]any `json:"metadata"`
+		} `json:"events"`
+		NextBefore int64 `json:"next_before"`
+	}
+	decode := func(rec *httptest.ResponseRecorder) page {
+		t.Helper()
+		assertStatus(t, rec, http.StatusOK)
+		var p page
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+		return p
+	}
+
+	first := decode(get("?limit=3"))
+	if len(first.Events) != 3 || first.Events[0].Type != "frozen" || first.NextBefore != first.Events[2].ID {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	if e := first.Events[0]; e.Actor != "webhook:shop/update" || e.Metadata["from"] != "active" {
+		t.Fatalf("unexpected frozen event %+v", e)
+	}
+	if e := first.Events[1]; e.Type != "reinstalled" || e.Actor != repository.ActorMerchant || e.IP == "" || e.Metadata["reinstall_count"] != float64(1) {
+		t.Fatalf("unexpected reinstall event %+v", e)
+	}
+
+	rest := decode(get(fmt.Sprintf("?before=%d", first.NextBefore)))
+	if len(rest.Events) != 4 || rest.Events[3].Type != "install_started" || rest.NextBefore != 0 {
+		t.Fatalf("unexpected last page %+v", rest)
+	}
+	if e := rest.Events[1]; e.Type != "scopes_changed" || e.Actor != "webhook:app/scopes_update" || e.Metadata["current"] != "read_products,read_orders" {
+		t.Fatalf("unexpected scopes event %+v", e)
+	}
+	// tokens never reach the history
+	if strings.Contains(get("").Body.String(), "shpat_") {
+		t.Fatal("history leaks an access token")
+	}
+
+	assertStatus(t, get("?limit=0"), http.StatusBadRequest)
+	assertStatus(t, get("?limit=1000"), http.StatusBadRequest)
+	assertStatus(t, get("?before=x"), http.StatusBadRequest)
+
+	other := fake.SessionToken("other-store.myshopify.com", hs.clock.Now())
+	req := httptest.NewRequest(http.MethodGet, "/api/shop/events", nil)
+	req.Header.Set("Authorization", "Bearer "+other)
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusNotFound)
+}
+
+// TestErrorPageStyleAllowedByCSP: error pages are sent with strictCSP, which blocks inline styles, so
+// the layout must load its stylesheet from our own origin
+func TestErrorPageStyleAllowedByCSP(t *testing.T) {
+	hs := newHarness(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/apps/default/login?shop=evil.com", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	hs.router.ServeHTTP(rec, req)
+	assertStatus(t, rec, http.StatusBadRequest)
+
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != strictCSP || !strings.Contains(csp, "default-src 'self'") {
+		t.Fatalf("unexpected CSP %q", csp)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "<style") || strings.Contains(body, "style=") {
+		t.Fatalf("inline styles are blocked by default-src 'self': %s", body)
+	}
+	const link = `<link rel="stylesheet" href="/static/app.css">`
+	if !strings.Contains(body, link) {
+		t.Fatalf("error page does not load the same-origin stylesheet: %s", body)
+	}
+
+	css := hs.get("/static/app.css")
+	assertStatus(t, css, http.StatusOK)
+	if ct := css.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") || !strings.Contains(css.Body.String(), ".error") {
+		t.Fatalf("unexpected stylesheet %q: %s", ct, css.Body.String())
+	}
+}
+
+// TestStartSessionErrors: only a failed session insert is a database error, a cookie that cannot be
+// sealed is our own failure
+func TestStartSessionErrors(t *testing.T) {
+	cases := map[string]struct {
+		setup func(hs *harness)
+		code  apperr.Code
+	}{
+		"session store": {func(hs *harness) { hs.sessions.createErr = errDB }, apperr.CodeDatabase},
+		"no session keys": {func(hs *harness) {
+			hs.cfg.SessionKeys = nil
+			hs.build(hs.tokens)
+		}, apperr.CodeInternal},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			hs := newHarness(t)
+			tc.setup(hs)
+
+			assertStatus(t, hs.get("/login?shop="+testShop), http.StatusFound)
+			rec := hs.get("/auth/callback?" + hs.callbackQuery(testShop, hs.states.onlyNonce(t)))
+			assertStatus(t, rec, http.StatusInternalServerError)
+			if !strings.Contains(rec.Body.String(), string(tc.code)) {
+				t.Fatalf("callback: want %s, got %s", tc.code, rec.Body.String())
+			}
+
+			launch := url.Values{}
+			launch.Set("shop", testShop)
+			rec = hs.get("/login?" + signedQuery(launch, hs.cfg.Apps[0].APISecret))
+			assertStatus(t, rec, http.StatusInternalServerError)
+			if !strings.Contains(rec.Body.String(), string(tc.code)) {
+				t.Fatalf("login: want %s, got %s", tc.code, rec.Body.String())
+			}
+		})
+	}
+}
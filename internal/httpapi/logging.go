@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"log/slog"
+	"regexp"
+	"shopify-auth-app/internal/config"
+	"shopify-auth-app/internal/logctx"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-Id"
+	requestIDKey    = "request_id"
+)
+
+// an incoming X-Request-Id is only trusted when it looks like an id, anything else is replaced
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestLogger accepts or generates an X-Request-Id, stores a logger carrying it in the request
+// context for handlers, repositories and the Shopify client, and writes one access log line per request
+func (h *Handlers) RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDRe.MatchString(id) {
+			id, _ = newNonce()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		log := h.log.With("request_id", id)
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			log = log.With("trace_id", sc.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(logctx.With(c.Request.Context(), log))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		app := ""
+		if v, ok := c.Get(appKey); ok {
+			app = v.(*config.App).Name
+		}
+		// shop is only set when SecurityHeaders could verify it, never taken from the raw query
+		log.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"status", status,
+			"latency", time.Since(start),
+			"app", app,
+			"shop", c.GetString(verifiedShopKey),
+			"ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		)
+	}
+}
+
+// requestID returns the id assigned by RequestLogger
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// logger returns the request-scoped logger, falling back to the handler logger
+func (h *Handlers) logger(c *gin.Context) *slog.Logger {
+	return logctx.From(c.Request.Context(), h.log)
+}
@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net/netip"
+	"shopify-auth-app/internal/apperr"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestMetrics records the duration of every request by route pattern
+func (h *Handlers) RequestMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			// unmatched paths share one label so scanners cannot blow up cardinality
+			route = "unmatched"
+		}
+		h.metrics.ObserveHTTP(route, c.Request.Method, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// Metrics serves the Prometheus registry to allowed addresses or to callers presenting METRICS_TOKEN
+func (h *Handlers) Metrics(c *gin.Context) {
+	if !h.metricsAllowed(c) {
+		h.fail(c, apperr.New(apperr.CodeForbidden, "metrics access denied"))
+		return
+	}
+	h.metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+func (h *Handlers) metricsAllowed(c *gin.Context) bool {
+	if token := h.cfg.MetricsToken; token != "" {
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	// ClientIP only honours forwarding headers from the router's trusted proxies
+	ip, err := netip.ParseAddr(c.ClientIP())
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range h.cfg.MetricsAllowedCIDRs {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"net/http"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppProxy answers storefront requests Shopify forwards through the app proxy
+// (https://<shop>/apps/<subpath>/... -> /proxy/...). Shopify signs them with the app secret
+func (h *Handlers) AppProxy(c *gin.Context) {
+	app := h.app(c)
+	if err := h.verifyProxySignature(c, app); err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeInvalidHMAC, err, "invalid proxy signature"))
+		return
+	}
+	shop, ok := normalizeAndValidateShop(c.Query("shop"))
+	if !ok {
+		h.fail(c, apperr.New(apperr.CodeInvalidShop, "invalid shop"))
+		return
+	}
+
+	s, err := h.shopRepo.GetByDomain(c.Request.Context(), app.APIKey, shop)
+	if err == repository.ErrNotFound {
+		h.fail(c, apperr.New(apperr.CodeShopNotInstalled, "shop not installed"), "shop", shop)
+		return
+	}
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+	if err := shopStateError(s); err != nil {
+		h.fail(c, err, "shop", shop, "shop_state", s.State)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shop":                  shop,
+		"path":                  c.Param("path"),
+		"logged_in_customer_id": c.Query("logged_in_customer_id"),
+	})
+}
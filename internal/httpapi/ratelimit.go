@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"math"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/ratelimit"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateCheck is one bucket a request has to take a token from. scope is the metrics label
+type rateCheck struct {
+	scope string
+	key   string
+	limit ratelimit.Limit
+}
+
+// RateLimit takes one token from the client IP bucket. Buckets are per route so a burst of logins
+// does not block callbacks. The shop bucket is charged by the handler once the shop is verified,
+// see allowShop
+func (h *Handlers) RateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// ClientIP only honours X-Forwarded-For from TRUSTED_PROXIES, so clients cannot pick their own bucket
+		if err := h.allow(c, rateCheck{"ip", c.FullPath() + ":ip:" + c.ClientIP(), h.cfg.RateLimitIP}); err != nil {
+			h.fail(c, err, "scope", "ip")
+			return
+		}
+		c.Next()
+	}
+}
+
+// allowShop takes one token from the bucket of a shop the request proved it comes from, through
+// the Shopify HMAC. Charging it on the unverified shop parameter would let anyone spend a merchant's
+// budget and block its installs
+func (h *Handlers) allowShop(c *gin.Context, shop string) error {
+	return h.allow(c, rateCheck{"shop", c.FullPath() + ":shop:" + shop, h.cfg.RateLimitShop})
+}
+
+// allow takes a token from the bucket of check and returns a rate_limited error with Retry-After set
+// when it is empty. A limiter error lets the request through: an outage of the limit store must not
+// stop installs
+func (h *Handlers) allow(c *gin.Context, check rateCheck) error {
+	if h.limiter == nil {
+		return nil
+	}
+	d, err := h.limiter.Allow(c.Request.Context(), check.key, check.limit)
+	if err != nil {
+		h.logger(c).Warn("rate limiter unavailable, allowing request", "scope", check.scope, "err", err)
+		return nil
+	}
+	if !d.Allowed {
+		h.metrics.RateLimited(c.FullPath(), check.scope)
+		c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(d.RetryAfter.Seconds())))))
+		return apperr.New(apperr.CodeRateLimited, "too many requests, retry later")
+	}
+	return nil
+}
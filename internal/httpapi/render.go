@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// the stylesheet is served from our own origin: strictCSP (default-src 'self') blocks inline styles
+//
+//go:embed static/*.css
+var staticFS embed.FS
+
+// pages are parsed once, each one together with the shared layout
+var pages = parsePages("dashboard.html", "error.html", "exit_iframe.html")
+
+func parsePages(names ...string) map[string]*template.Template {
+	out := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		out[name] = template.Must(template.ParseFS(templateFS, "templates/layout.html", "templates/"+name))
+	}
+	return out
+}
+
+type errorPage struct {
+	Title     string
+	Message   string
+	Code      string
+	RequestID string
+}
+
+// renderHTML executes page into a buffer first so a template error never produces a half written page
+func (h *Handlers) renderHTML(c *gin.Context, status int, page string, data any) {
+	var buf bytes.Buffer
+	if err := pages[page].ExecuteTemplate(&buf, "layout", data); err != nil {
+		h.logger(c).Error("failed to render template", "page", page, "err", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
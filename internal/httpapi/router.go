@@ -1,20 +1,60 @@
 package httpapi
 
-import "github.com/gin-gonic/gin"
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"shopify-auth-app/internal/apperr"
+
+	"github.com/gin-gonic/gin"
+)
 
 func NewRouter(h *Handlers) *gin.Engine {
 	r := gin.New()
 
-	//Logger and Recovery global middlewares
-	r.Use(gin.Logger())
-	r.Use(gin.Recovery())
+	// Tracing and RequestLogger run outermost so span and access log also see panics turned into 500 by Recovery
+	r.Use(h.Tracing())
+	r.Use(h.RequestLogger())
+	r.Use(h.RequestMetrics())
+	// the default Recovery dumps the raw request, cookies included, so panics go through fail instead
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		h.fail(c, apperr.New(apperr.CodeInternal, "internal error"), "panic", fmt.Sprint(recovered))
+	}))
+	r.Use(h.SecurityHeaders())
+
+	// only these peers may set X-Forwarded-For; ClientIP feeds rate limits, metrics access and logs
+	proxies := make([]string, 0, len(h.cfg.TrustedProxies))
+	for _, p := range h.cfg.TrustedProxies {
+		proxies = append(proxies, p.String())
+	}
+	_ = r.SetTrustedProxies(proxies)
 
-	_ = r.SetTrustedProxies([]string{"127.0.0.1", "::1"})
+	r.StaticFileFS("/static/app.css", "static/app.css", http.FS(staticFS))
 
 	r.GET("/health", h.Health)
-	r.GET("/login", h.Login)
-	r.GET("/auth/callback", h.OAuthCallback)
-	r.GET("/dashboard", h.Dashboard)
+	r.GET("/livez", h.Livez)
+	r.GET("/readyz", h.Readyz)
+	if h.cfg.MetricsEnabled {
+		r.GET("/metrics", h.Metrics)
+	}
+
+	// every app is served under /apps/<name or api key>/, the default app also on the bare paths
+	for _, prefix := range []string{"", "/apps/:app"} {
+		g := r.Group(prefix, h.ResolveApp())
+		g.GET("/login", h.RateLimit(), h.Login)
+		g.GET("/auth/callback", h.RateLimit(), h.OAuthCallback)
+		g.GET("/dashboard", h.Dashboard)
+		g.POST("/logout", h.Logout)
+
+		g.POST("/webhooks/app/scopes_update", h.AppScopesUpdate)
+		g.POST("/webhooks/app/uninstalled", h.AppUninstalled)
+		g.POST("/webhooks/shop/update", h.ShopUpdate)
+		g.POST("/webhooks/shop/redact", h.ShopRedact)
+
+		g.GET("/api/shop", h.SessionTokenAuth(), h.APIShop)
+		g.GET("/api/shop/events", h.SessionTokenAuth(), h.APIShopEvents)
+		g.GET("/proxy/*path", h.AppProxy)
+	}
 
 	return r
 }
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	verifiedShopKey = "verified_shop"
+
+	// strictCSP is sent when we cannot tell which shop the request belongs to
+	strictCSP = "default-src 'self'; base-uri 'self'; form-action 'self'; object-src 'none'; frame-ancestors 'none'"
+)
+
+// SecurityHeaders sets the standard security headers and a Content-Security-Policy whose
+// frame-ancestors only allow the verified shop's admin to embed the app
+func (h *Handlers) SecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if h.cfg.IsProduction() {
+			header.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+
+		if shop, ok := h.verifiedShop(c); ok {
+			c.Set(verifiedShopKey, shop)
+			header.Set("Content-Security-Policy", shopCSP(shop))
+		} else {
+			header.Set("Content-Security-Policy", strictCSP)
+			header.Set("X-Frame-Options", "DENY")
+		}
+
+		c.Next()
+	}
+}
+
+// shopCSP allows the shop's own admin and the unified admin to frame the app
+func shopCSP(shop string) string {
+	return "frame-ancestors https://" + shop + " https://admin.shopify.com; base-uri 'self'; object-src 'none'"
+}
+
+// verifiedShop returns the shop proven by a query signed with the route's app secrets, by an App Bridge
+// session token or by that app's session cookie. An unverified ?shop= must never widen frame-ancestors
+func (h *Handlers) verifiedShop(c *gin.Context) (string, bool) {
+	app, ok := h.resolveApp(c)
+	if !ok {
+		return "", false
+	}
+
+	if c.Query("hmac") != "" {
+		shop, ok := normalizeAndValidateShop(c.Query("shop"))
+		if ok && h.verifyQueryHMAC(c, app) == nil {
+			return shop, true
+		}
+	}
+
+	if c.GetHeader("Authorization") != "" {
+		if tok, err := h.verifySessionToken(c, app); err == nil {
+			if shop, ok := normalizeAndValidateShop(tok.Shop()); ok {
+				return shop, true
+			}
+		}
+	}
+
+	if cookie, err := c.Cookie(h.cookieName(app)); err == nil {
+		if p, err := h.cookies.open(cookie, h.now()); err == nil && p.App == app.APIKey {
+			if shop, ok := normalizeAndValidateShop(p.Shop); ok {
+				return shop, true
+			}
+		}
+	}
+	return "", false
+}
@@ -1,69 +1,112 @@
 package httpapi
 
 import (
-	"crypto/hmac"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"shopify-auth-app/internal/config"
 	"strings"
 	"time"
 )
 
+const (
+	sessionCookieName = "app_session"
+	sessionTTL        = 15 * time.Minute
+)
+
+// sessionPayload is what the encrypted cookie carries. SID references the server-side sessions row
 type sessionPayload struct {
+	SID  string `json:"sid"`
+	App  string `json:"app"` // API key of the app the session was opened for
 	Shop string `json:"shop"`
 	Exp  int64  `json:"exp"` // unix seconds
 }
 
-func signSession(shop, secret string, ttl time.Duration) (string, error) {
-	p := sessionPayload{
-		Shop: shop,
-		Exp:  time.Now().Add(ttl).Unix(),
+type sessionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// sessionCodec encrypts cookies with AES-GCM. The first configured key seals new cookies,
+// every key is accepted when opening so a secret can be rotated without logging everyone out
+type sessionCodec struct {
+	primary *sessionKey
+	keys    map[string]*sessionKey
+}
+
+func newSessionCodec(keys []config.SessionKey) *sessionCodec {
+	sc := &sessionCodec{keys: map[string]*sessionKey{}}
+	for i, k := range keys {
+		// secrets are arbitrary strings, hash them to a 256-bit AES key
+		sum := sha256.Sum256([]byte(k.Secret))
+		block, _ := aes.NewCipher(sum[:])
+		aead, _ := cipher.NewGCM(block)
+
+		key := &sessionKey{id: k.ID, aead: aead}
+		sc.keys[k.ID] = key
+		if i == 0 {
+			sc.primary = key
+		}
 	}
-	b, err := json.Marshal(p)
+	return sc
+}
+
+// seal returns "<key id>.<base64url(nonce|ciphertext)>". The key id is bound as additional data
+func (sc *sessionCodec) seal(sid, app, shop string, exp time.Time) (string, error) {
+	if sc.primary == nil {
+		return "", errors.New("no session keys configured")
+	}
+
+	b, err := json.Marshal(sessionPayload{
+		SID:  sid,
+		App:  app,
+		Shop: shop,
+		Exp:  exp.Unix(),
+	})
 	if err != nil {
 		return "", err
 	}
 
-	payload := base64.RawURLEncoding.EncodeToString(b)
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(payload))
-	sig := hex.EncodeToString(mac.Sum(nil))
+	nonce := make([]byte, sc.primary.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := sc.primary.aead.Seal(nonce, nonce, b, []byte(sc.primary.id))
 
-	return payload + "." + sig, nil
+	return sc.primary.id + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
 }
 
-func verifySession(value, secret string) (string, error) {
-	parts := strings.Split(value, ".")
-	if len(parts) != 2 {
-		return "", errors.New("invalid session format")
+func (sc *sessionCodec) open(value string, now time.Time) (*sessionPayload, error) {
+	kid, data, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errors.New("invalid session format")
 	}
-	payload, sigHex := parts[0], parts[1]
-
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(payload))
-	expected := mac.Sum(nil)
-
-	got, err := hex.DecodeString(sigHex)
-	if err != nil {
-		return "", errors.New("invalid session signature")
+	key, ok := sc.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown session key")
 	}
-	if !hmac.Equal(expected, got) {
-		return "", errors.New("invalid session signature")
+
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return nil, errors.New("invalid session format")
 	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
 
-	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	raw, err := key.aead.Open(nil, nonce, ciphertext, []byte(kid))
 	if err != nil {
-		return "", errors.New("invalid session payload")
+		return nil, errors.New("invalid session signature")
 	}
 
 	var p sessionPayload
-	if err := json.Unmarshal(raw, &p); err != nil {
-		return "", errors.New("invalid session payload")
+	if err := json.Unmarshal(raw, &p); err != nil || p.SID == "" {
+		return nil, errors.New("invalid session payload")
 	}
-	if time.Now().Unix() > p.Exp {
-		return "", errors.New("session expired")
+	if now.Unix() > p.Exp {
+		return nil, errors.New("session expired")
 	}
-	return p.Shop, nil
+	return &p, nil
 }
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"shopify-auth-app/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCodec_RoundTrip(t *testing.T) {
+	sc := newSessionCodec([]config.SessionKey{{ID: "k2", Secret: "new-secret"}})
+	now := time.Unix(1_700_000_000, 0)
+
+	v, err := sc.seal("sid-1", "api-key", "test-store.myshopify.com", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !strings.HasPrefix(v, "k2.") {
+		t.Fatalf("cookie is not tagged with key id: %s", v)
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(v, "k2."))
+	if strings.Contains(string(raw), "test-store") || strings.Contains(v, "test-store") {
+		t.Fatalf("shop domain readable in cookie")
+	}
+
+	p, err := sc.open(v, now)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if p.SID != "sid-1" || p.Shop != "test-store.myshopify.com" {
+		t.Fatalf("unexpected payload %+v", p)
+	}
+
+	if _, err := sc.open(v, now.Add(2*time.Minute)); err == nil {
+		t.Fatalf("expected expired session to fail")
+	}
+}
+
+func TestSessionCodec_Rotation(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	old := newSessionCodec([]config.SessionKey{{ID: "k1", Secret: "old-secret"}})
+	v, err := old.seal("sid-1", "api-key", "test-store.myshopify.com", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	// k2 signs, k1 is still accepted for verification
+	rotated := newSessionCodec([]config.SessionKey{{ID: "k2", Secret: "new-secret"}, {ID: "k1", Secret: "old-secret"}})
+	if _, err := rotated.open(v, now); err != nil {
+		t.Fatalf("old cookie rejected during rotation: %v", err)
+	}
+	fresh, _ := rotated.seal("sid-2", "api-key", "test-store.myshopify.com", now.Add(time.Minute))
+	if !strings.HasPrefix(fresh, "k2.") {
+		t.Fatalf("new cookies must use the primary key: %s", fresh)
+	}
+
+	// once k1 is dropped its cookies stop working
+	dropped := newSessionCodec([]config.SessionKey{{ID: "k2", Secret: "new-secret"}})
+	if _, err := dropped.open(v, now); err == nil {
+		t.Fatalf("expected cookie for removed key to fail")
+	}
+}
+
+func TestSessionCodec_Tampering(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	sc := newSessionCodec([]config.SessionKey{{ID: "k1", Secret: "secret"}, {ID: "k2", Secret: "other"}})
+	v, _ := sc.seal("sid-1", "api-key", "test-store.myshopify.com", now.Add(time.Minute))
+
+	_, data, _ := strings.Cut(v, ".")
+	cases := []string{
+		"",
+		"garbage",
+		"k1.",
+		"k2." + data, // key id swapped, bound as additional data
+		"k1." + data[:len(data)-2] + "AA",
+	}
+	for _, c := range cases {
+		if _, err := sc.open(c, now); err == nil {
+			t.Fatalf("expected %q to be rejected", c)
+		}
+	}
+}
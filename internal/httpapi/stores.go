@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"context"
+	"shopify-auth-app/internal/repository"
+	"shopify-auth-app/internal/shopify"
+	"time"
+)
+
+// ShopStore is the subset of repository.ShopRepository the handlers use
+type ShopStore interface {
+	GetByDomain(ctx context.Context, apiKey, shopDomain string) (*repository.Shop, error)
+	BeginInstall(ctx context.Context, apiKey, shopDomain string) (*repository.Shop, error)
+	Install(ctx context.Context, apiKey, shopDomain, token, scopes string) (*repository.Shop, error)
+	Transition(ctx context.Context, apiKey, shopDomain string, to repository.ShopState) (*repository.Shop, error)
+	UpdateScopes(ctx context.Context, apiKey, shopDomain, scopes string) error
+	UpdateMetadata(ctx context.Context, apiKey, shopDomain string, m repository.ShopMetadata) error
+	Timeline(ctx context.Context, apiKey, shopDomain string, before int64, limit int) ([]repository.ShopEvent, error)
+}
+
+// StateStore is the subset of repository.StateRepository the handlers use
+type StateStore interface {
+	Create(ctx context.Context, apiKey, shopDomain, nonce, host string, ttl time.Duration) error
+	Consume(ctx context.Context, apiKey, shopDomain, nonce string) (string, bool, error)
+}
+
+// TokenExchanger trades an OAuth authorization code for an offline access token of app
+type TokenExchanger interface {
+	ExchangeCodeForApp(ctx context.Context, app shopify.Credentials, shopDomain, code string) (*shopify.AccessTokenResponse, error)
+}
+
+// ShopSyncer fetches a shop's metadata from Shopify in the background, see worker.ShopSyncer
+type ShopSyncer interface {
+	Enqueue(apiKey, shopDomain string)
+}
+
+// SessionStore is the subset of repository.SessionRepository the handlers use
+type SessionStore interface {
+	Create(ctx context.Context, s repository.Session) error
+	GetActive(ctx context.Context, id string) (*repository.Session, error)
+	Touch(ctx context.Context, id string) error
+	Revoke(ctx context.Context, id string) error
+	RevokeAllForShop(ctx context.Context, apiKey, shopDomain string) (int64, error)
+}
@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"errors"
+	"shopify-auth-app/internal/config"
+	"shopify-auth-app/internal/shopify"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifier labels of the secret_matches_total metric
+const (
+	verifierQueryHMAC    = "query_hmac"
+	verifierWebhookHMAC  = "webhook_hmac"
+	verifierSessionToken = "session_token"
+	verifierAppProxy     = "app_proxy"
+)
+
+// context keys caching verification results: SecurityHeaders and the handler both verify the same
+// request, the signature is checked and counted once
+const (
+	queryHMACKey    = "query_hmac"
+	sessionTokenKey = "session_token"
+)
+
+var errNoBearer = errors.New("missing bearer token")
+
+type sessionTokenResult struct {
+	token shopify.SessionToken
+	err   error
+}
+
+// verifyQueryHMAC checks the hmac of a Shopify-signed query with both secrets of app
+func (h *Handlers) verifyQueryHMAC(c *gin.Context, app *config.App) error {
+	if v, ok := c.Get(queryHMACKey); ok {
+		err, _ := v.(error)
+		return err
+	}
+
+	secret, err := shopify.VerifyHMAC(c.Request.URL.Query(), secrets(app))
+	if err == nil {
+		h.metrics.SecretMatched(app.Name, verifierQueryHMAC, secret)
+	}
+	c.Set(queryHMACKey, err)
+	return err
+}
+
+// verifySessionToken checks the App Bridge session token sent as "Authorization: Bearer <token>"
+func (h *Handlers) verifySessionToken(c *gin.Context, app *config.App) (shopify.SessionToken, error) {
+	if v, ok := c.Get(sessionTokenKey); ok {
+		r := v.(sessionTokenResult)
+		return r.token, r.err
+	}
+
+	var r sessionTokenResult
+	raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || raw == "" {
+		r.err = errNoBearer
+	} else {
+		var secret string
+		r.token, secret, r.err = shopify.VerifySessionToken(raw, app.APIKey, secrets(app), h.now())
+		if r.err == nil {
+			h.metrics.SecretMatched(app.Name, verifierSessionToken, secret)
+		}
+	}
+	c.Set(sessionTokenKey, r)
+	return r.token, r.err
+}
+
+// verifyWebhookHMAC checks the X-Shopify-Hmac-Sha256 header of a webhook body with both secrets of app
+func (h *Handlers) verifyWebhookHMAC(c *gin.Context, app *config.App, body []byte) error {
+	secret, err := shopify.VerifyWebhookHMAC(body, c.GetHeader("X-Shopify-Hmac-Sha256"), secrets(app))
+	if err == nil {
+		h.metrics.SecretMatched(app.Name, verifierWebhookHMAC, secret)
+	}
+	return err
+}
+
+// verifyProxySignature checks the signature of a request Shopify forwarded through the app proxy
+func (h *Handlers) verifyProxySignature(c *gin.Context, app *config.App) error {
+	secret, err := shopify.VerifyProxySignature(c.Request.URL.Query(), secrets(app), h.now())
+	if err == nil {
+		h.metrics.SecretMatched(app.Name, verifierAppProxy, secret)
+	}
+	return err
+}
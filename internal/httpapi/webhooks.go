@@ -0,0 +1,210 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"shopify-auth-app/internal/apperr"
+	"shopify-auth-app/internal/repository"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// max webhook payload we are willing to read
+const maxWebhookBody = 1 << 20
+
+type scopesUpdatePayload struct {
+	Previous []string `json:"previous"`
+	Current  []string `json:"current"`
+}
+
+// readWebhook verifies the Shopify webhook signature with the route's app secrets and returns the normalized
+// shop and raw body. It writes the error response itself and returns ok=false when the request must be rejected
+func (h *Handlers) readWebhook(c *gin.Context) (string, []byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeInvalidPayload, err, "failed to read body"))
+		return "", nil, false
+	}
+
+	if err := h.verifyWebhookHMAC(c, h.app(c), body); err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeInvalidWebhook, err, "invalid webhook signature"))
+		return "", nil, false
+	}
+
+	shop, ok := normalizeAndValidateShop(c.GetHeader("X-Shopify-Shop-Domain"))
+	if !ok {
+		h.fail(c, apperr.New(apperr.CodeInvalidShop, "invalid shop"))
+		return "", nil, false
+	}
+
+	// the topic comes from the route, X-Shopify-Topic is not covered by the signature
+	_, topic, _ := strings.Cut(c.FullPath(), "/webhooks/")
+	c.Request = c.Request.WithContext(withActor(c, repository.WebhookActor(topic)))
+	return shop, body, true
+}
+
+// AppScopesUpdate handles the app/scopes_update webhook sent when a merchant grants or revokes optional scopes
+func (h *Handlers) AppScopesUpdate(c *gin.Context) {
+	shop, body, ok := h.readWebhook(c)
+	if !ok {
+		return
+	}
+
+	var p scopesUpdatePayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeInvalidPayload, err, "invalid payload"), "shop", shop)
+		return
+	}
+
+	err := h.shopRepo.UpdateScopes(c.Request.Context(), h.app(c).APIKey, shop, repository.JoinScopes(p.Current))
+	if err == repository.ErrNotFound {
+		// nothing stored for this shop, acknowledge so Shopify stops retrying
+		c.Status(http.StatusOK)
+		return
+	}
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to update scopes"), "shop", shop)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// AppUninstalled handles the app/uninstalled webhook: the offline token is dead, so the shop moves to
+// uninstalled, which drops the token, and every session of the shop is revoked. The row is kept so a
+// reinstall is recognized. Installations of other apps are left alone
+func (h *Handlers) AppUninstalled(c *gin.Context) {
+	shop, _, ok := h.readWebhook(c)
+	if !ok {
+		return
+	}
+	app := h.app(c)
+
+	ctx := c.Request.Context()
+
+	revoked, err := h.sessions.RevokeAllForShop(ctx, app.APIKey, shop)
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to revoke sessions"), "shop", shop)
+		return
+	}
+
+	if !h.webhookTransition(c, app.APIKey, shop, repository.StateUninstalled) {
+		return
+	}
+
+	h.logger(c).Info("app uninstalled", "shop", shop, "revoked_sessions", revoked)
+	c.Status(http.StatusOK)
+}
+
+// ShopRedact handles the shop/redact compliance webhook Shopify sends 48 hours after an uninstall:
+// the shop's data is erased and the row kept as redacted
+func (h *Handlers) ShopRedact(c *gin.Context) {
+	shop, _, ok := h.readWebhook(c)
+	if !ok {
+		return
+	}
+
+	if !h.webhookTransition(c, h.app(c).APIKey, shop, repository.StateRedacted) {
+		return
+	}
+
+	h.logger(c).Info("shop redacted", "shop", shop)
+	c.Status(http.StatusOK)
+}
+
+// webhookTransition moves a shop to the state a webhook reports. Unknown shops and transitions the
+// lifecycle refuses, such as a late delivery after a reinstall, are logged and acknowledged: Shopify
+// would retry them forever. It returns false after failing the request
+func (h *Handlers) webhookTransition(c *gin.Context, apiKey, shop string, to repository.ShopState) bool {
+	_, err := h.shopRepo.Transition(c.Request.Context(), apiKey, shop, to)
+	switch {
+	case err == nil, err == repository.ErrNotFound:
+		return true
+	case errors.Is(err, repository.ErrInvalidTransition):
+		h.logger(c).Warn("ignoring webhook state change", "shop", shop, "err", err)
+		return true
+	default:
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to update shop state"), "shop", shop, "to_state", to)
+		return false
+	}
+}
+
+// shopUpdatePayload is the part of the shop/update webhook (the REST shop resource) we keep
+type shopUpdatePayload struct {
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	CustomerEmail   string `json:"customer_email"`
+	PlanName        string `json:"plan_name"`
+	PlanDisplayName string `json:"plan_display_name"`
+	Currency        string `json:"currency"`
+	IanaTimezone    string `json:"iana_timezone"`
+	Domain          string `json:"domain"`
+}
+
+// frozenPlan is the plan_name of a shop Shopify froze for an unpaid bill
+const frozenPlan = "frozen"
+
+// ShopUpdate handles the shop/update webhook sent when a merchant changes the shop's name, email,
+// plan, currency or domain, and keeps the synced metadata current. A frozen plan freezes the shop,
+// any other plan unfreezes it
+func (h *Handlers) ShopUpdate(c *gin.Context) {
+	shop, body, ok := h.readWebhook(c)
+	if !ok {
+		return
+	}
+
+	var p shopUpdatePayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeInvalidPayload, err, "invalid payload"), "shop", shop)
+		return
+	}
+
+	app := h.app(c)
+	ctx := c.Request.Context()
+
+	current, err := h.shopRepo.GetByDomain(ctx, app.APIKey, shop)
+	if err == repository.ErrNotFound || (err == nil && !current.State.Installed()) {
+		// nothing installed for this shop, acknowledge so Shopify stops retrying
+		c.Status(http.StatusOK)
+		return
+	}
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "database error"), "shop", shop)
+		return
+	}
+
+	err = h.shopRepo.UpdateMetadata(ctx, app.APIKey, shop, repository.ShopMetadata{
+		Name:          p.Name,
+		Email:         p.Email,
+		ContactEmail:  p.CustomerEmail,
+		PlanName:      p.PlanDisplayName,
+		CurrencyCode:  p.Currency,
+		IanaTimezone:  p.IanaTimezone,
+		PrimaryDomain: strings.ToLower(p.Domain),
+	})
+	if err == repository.ErrNotFound {
+		// nothing stored for this shop, acknowledge so Shopify stops retrying
+		c.Status(http.StatusOK)
+		return
+	}
+	if err != nil {
+		h.fail(c, apperr.Wrap(apperr.CodeDatabase, err, "failed to update shop metadata"), "shop", shop)
+		return
+	}
+
+	switch {
+	case p.PlanName == frozenPlan && current.State != repository.StateFrozen:
+		if !h.webhookTransition(c, app.APIKey, shop, repository.StateFrozen) {
+			return
+		}
+	case p.PlanName != frozenPlan && current.State == repository.StateFrozen:
+		if !h.webhookTransition(c, app.APIKey, shop, repository.StateActive) {
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,27 @@
+// Package logctx carries a request-scoped *slog.Logger through context.Context so that handlers,
+// repositories and the Shopify client all log with the same request id.
+package logctx
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// With returns a copy of ctx carrying logger
+func With(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// From returns the logger stored in ctx, or fallback when there is none.
+// A nil fallback means slog.Default()
+func From(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && l != nil {
+		return l
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return slog.Default()
+}
@@ -0,0 +1,136 @@
+// Package metrics owns the Prometheus registry and every collector the app exports on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shopify_auth"
+
+// Metrics is created once in main and shared by handlers, the Shopify client and the pool collector.
+// A private registry keeps tests independent and avoids global state
+type Metrics struct {
+	registry *prometheus.Registry
+
+	loginStarts      *prometheus.CounterVec
+	callbackOutcomes *prometheus.CounterVec
+	tokenExchange    *prometheus.HistogramVec
+	shopifyRequests  *prometheus.HistogramVec
+	httpRequests     *prometheus.HistogramVec
+	rateLimited      *prometheus.CounterVec
+	secretMatches    *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		loginStarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_starts_total",
+			Help:      "Logins by how they continued: authorize (OAuth started), session (installed shop) or exit_iframe.",
+		}, []string{"flow"}),
+		callbackOutcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "oauth_callbacks_total",
+			Help:      "OAuth callbacks by outcome: success or the error code that rejected them.",
+		}, []string{"outcome"}),
+		tokenExchange: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "token_exchange_duration_seconds",
+			Help:      "Time spent exchanging the authorization code for an access token, retries included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		shopifyRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "shopify_request_duration_seconds",
+			Help:      "Latency of single Shopify API attempts by endpoint and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint", "status"}),
+		httpRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests served, by route pattern, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Requests rejected with 429, by route pattern and the bucket that ran out: ip or shop.",
+		}, []string{"route", "scope"}),
+		secretMatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "secret_matches_total",
+			Help:      "Verified Shopify signatures by app, verifier and the secret that matched: primary or secondary.",
+		}, []string{"app", "verifier", "secret"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.loginStarts,
+		m.callbackOutcomes,
+		m.tokenExchange,
+		m.shopifyRequests,
+		m.httpRequests,
+		m.rateLimited,
+		m.secretMatches,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Register adds an extra collector, such as the pgxpool stats
+func (m *Metrics) Register(c prometheus.Collector) {
+	m.registry.MustRegister(c)
+}
+
+func (m *Metrics) LoginStarted(flow string) {
+	m.loginStarts.WithLabelValues(flow).Inc()
+}
+
+// CallbackOutcome counts one OAuth callback; outcome is "success" or an apperr code
+func (m *Metrics) CallbackOutcome(outcome string) {
+	m.callbackOutcomes.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) ObserveTokenExchange(d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.tokenExchange.WithLabelValues(result).Observe(d.Seconds())
+}
+
+// ObserveShopifyRequest matches shopify.RequestObserver. status is 0 when no response was received
+func (m *Metrics) ObserveShopifyRequest(endpoint string, status int, d time.Duration) {
+	code := "error"
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	m.shopifyRequests.WithLabelValues(endpoint, code).Observe(d.Seconds())
+}
+
+// ObserveHTTP records one served request. route must be the route pattern, never the raw path
+func (m *Metrics) ObserveHTTP(route, method string, status int, d time.Duration) {
+	m.httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+// RateLimited counts one rejected request; scope is "ip" or "shop"
+func (m *Metrics) RateLimited(route, scope string) {
+	m.rateLimited.WithLabelValues(route, scope).Inc()
+}
+
+// SecretMatched counts one verified signature. Once secondary stays flat for an app its old secret can be dropped
+func (m *Metrics) SecretMatched(app, verifier, secret string) {
+	m.secretMatches.WithLabelValues(app, verifier, secret).Inc()
+}
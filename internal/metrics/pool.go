@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reads pgxpool.Stat on every scrape instead of polling in the background
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns   *prometheus.Desc
+	idleConns       *prometheus.Desc
+	totalConns      *prometheus.Desc
+	maxConns        *prometheus.Desc
+	acquireCount    *prometheus.Desc
+	acquireDuration *prometheus.Desc
+	emptyAcquire    *prometheus.Desc
+	canceledAcquire *prometheus.Desc
+	newConns        *prometheus.Desc
+}
+
+// NewPoolCollector exports the statistics of pool
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool:            pool,
+		acquiredConns:   desc("acquired_conns", "Connections currently in use."),
+		idleConns:       desc("idle_conns", "Idle connections in the pool."),
+		totalConns:      desc("total_conns", "Open connections, acquired, idle and being established."),
+		maxConns:        desc("max_conns", "Configured maximum pool size."),
+		acquireCount:    desc("acquires_total", "Successful connection acquires."),
+		acquireDuration: desc("acquire_duration_seconds_total", "Total time spent waiting for a connection."),
+		emptyAcquire:    desc("empty_acquires_total", "Acquires that had to wait because the pool was empty."),
+		canceledAcquire: desc("canceled_acquires_total", "Acquires cancelled by their context."),
+		newConns:        desc("new_conns_total", "Connections opened."),
+	}
+}
+
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		p.acquiredConns, p.idleConns, p.totalConns, p.maxConns,
+		p.acquireCount, p.acquireDuration, p.emptyAcquire, p.canceledAcquire, p.newConns,
+	} {
+		ch <- d
+	}
+}
+
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := p.pool.Stat()
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+
+	gauge(p.acquiredConns, float64(s.AcquiredConns()))
+	gauge(p.idleConns, float64(s.IdleConns()))
+	gauge(p.totalConns, float64(s.TotalConns()))
+	gauge(p.maxConns, float64(s.MaxConns()))
+	counter(p.acquireCount, float64(s.AcquireCount()))
+	counter(p.acquireDuration, s.AcquireDuration().Seconds())
+	counter(p.emptyAcquire, float64(s.EmptyAcquireCount()))
+	counter(p.canceledAcquire, float64(s.CanceledAcquireCount()))
+	counter(p.newConns, float64(s.NewConnsCount()))
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery bounds how often idle buckets are dropped
+const sweepEvery = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket is refilled completely and can be forgotten
+	full time.Time
+}
+
+// Memory keeps buckets in process memory. Limits only hold per instance
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	burst := float64(limit.Burst)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.rate())
+	b.last = now
+
+	d := Decision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+		d.Remaining = int(b.tokens)
+	} else {
+		d.RetryAfter = limit.retryAfter(b.tokens)
+	}
+	b.full = now.Add(time.Duration((burst - b.tokens) / limit.rate() * float64(time.Second)))
+	return d, nil
+}
+
+// sweep drops buckets that have refilled completely, they behave exactly like missing ones
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepEvery {
+		return
+	}
+	m.lastSweep = now
+	for k, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, k)
+		}
+	}
+}
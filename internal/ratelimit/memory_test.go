@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	l, err := ParseLimit(" 30/1m ")
+	if err != nil || l.Burst != 30 || l.Period != time.Minute {
+		t.Fatalf("unexpected limit %+v, %v", l, err)
+	}
+	for _, bad := range []string{"", "30", "0/1m", "-1/1m", "x/1m", "30/", "30/0s", "30/soon"} {
+		if _, err := ParseLimit(bad); err == nil {
+			t.Fatalf("ParseLimit(%q) must fail", bad)
+		}
+	}
+}
+
+func TestMemory_BurstRefillAndRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	limit := Limit{Burst: 3, Period: 30 * time.Second} // one token every 10s
+
+	for i := 2; i >= 0; i-- {
+		d, _ := m.Allow(ctx, "k", limit)
+		if !d.Allowed || d.Remaining != i {
+			t.Fatalf("request within burst denied: %+v", d)
+		}
+	}
+	d, _ := m.Allow(ctx, "k", limit)
+	if d.Allowed || d.RetryAfter != 10*time.Second {
+		t.Fatalf("expected deny with 10s retry, got %+v", d)
+	}
+
+	// other keys have their own bucket
+	if d, _ := m.Allow(ctx, "other", limit); !d.Allowed {
+		t.Fatal("unrelated key was limited")
+	}
+
+	now = now.Add(4 * time.Second)
+	if d, _ := m.Allow(ctx, "k", limit); d.Allowed || d.RetryAfter != 6*time.Second {
+		t.Fatalf("expected deny with 6s retry, got %+v", d)
+	}
+
+	now = now.Add(6 * time.Second)
+	if d, _ := m.Allow(ctx, "k", limit); !d.Allowed {
+		t.Fatalf("token should have refilled: %+v", d)
+	}
+}
+
+func TestMemory_SweepsFullBuckets(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	limit := Limit{Burst: 2, Period: time.Second}
+
+	_, _ = m.Allow(context.Background(), "k", limit)
+	now = now.Add(2 * sweepEvery)
+	_, _ = m.Allow(context.Background(), "fresh", limit)
+
+	if _, ok := m.buckets["k"]; ok {
+		t.Fatal("refilled bucket was not swept")
+	}
+	if _, ok := m.buckets["fresh"]; !ok {
+		t.Fatal("bucket in use was swept")
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pruneAfter is how long an untouched bucket is kept; no configured limit should take longer to refill
+const pruneAfter = 24 * time.Hour
+
+// Postgres keeps buckets in the rate_limit_buckets table so every replica sees the same counts.
+// Each Allow is a single upsert, so concurrent requests serialize on the row lock
+type Postgres struct {
+	pool *pgxpool.Pool
+	// lastPrune is the unix time of the last cleanup, at most one per minute across requests
+	lastPrune atomic.Int64
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	// $2 is the burst, $3 the refill rate per second. SET expressions see the old row through b
+	const q = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES ($1, $2::float8 - 1, TRUE, NOW())
+ON CONFLICT (key) DO UPDATE
+SET allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * $3::float8) >= 1,
+    tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * $3::float8)
+             - CASE WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * $3::float8) >= 1 THEN 1 ELSE 0 END,
+    updated_at = NOW()
+RETURNING tokens, allowed;
+`
+	var (
+		tokens  float64
+		allowed bool
+	)
+	if err := p.pool.QueryRow(ctx, q, key, float64(limit.Burst), limit.rate()).Scan(&tokens, &allowed); err != nil {
+		return Decision{}, err
+	}
+	p.maybePrune(ctx)
+
+	if !allowed {
+		return Decision{RetryAfter: limit.retryAfter(tokens)}, nil
+	}
+	return Decision{Allowed: true, Remaining: int(tokens)}, nil
+}
+
+// maybePrune deletes idle buckets, piggybacking on a request at most once per minute
+func (p *Postgres) maybePrune(ctx context.Context) {
+	now := time.Now().Unix()
+	last := p.lastPrune.Load()
+	if now-last < int64(sweepEvery.Seconds()) || !p.lastPrune.CompareAndSwap(last, now) {
+		return
+	}
+
+	const q = `
+DELETE FROM rate_limit_buckets
+WHERE updated_at < NOW() - $1::interval;
+`
+	// best effort, a failed cleanup is retried a minute later
+	_, _ = p.pool.Exec(ctx, q, pruneAfter.String())
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+)
+
+// testPool connects to the test database; migrations must have been applied
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	_ = godotenv.Load("../../.env")
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		dsn = os.Getenv("DATABASE_URL")
+	}
+	if dsn == "" {
+		t.Skip("set TEST_DATABASE_URL or DATABASE_URL")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestPostgres_SharedBucket(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("test:%d", time.Now().UnixNano())
+	t.Cleanup(func() { _, _ = pool.Exec(context.Background(), "DELETE FROM rate_limit_buckets WHERE key = $1", key) })
+
+	// two limiters stand in for two replicas
+	a, b := NewPostgres(pool), NewPostgres(pool)
+	limit := Limit{Burst: 2, Period: time.Hour}
+
+	for _, l := range []*Postgres{a, b} {
+		d, err := l.Allow(ctx, key, limit)
+		if err != nil || !d.Allowed {
+			t.Fatalf("request within burst denied: %+v, %v", d, err)
+		}
+	}
+	d, err := a.Allow(ctx, key, limit)
+	if err != nil || d.Allowed {
+		t.Fatalf("third request must be denied: %+v, %v", d, err)
+	}
+	if d.RetryAfter <= 0 || d.RetryAfter > 30*time.Minute {
+		t.Fatalf("unexpected retry after %v", d.RetryAfter)
+	}
+}
@@ -0,0 +1,62 @@
+// Package ratelimit implements token bucket limits with an in-memory backend for a single instance
+// and a Postgres backend shared by every replica.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled at Burst per Period
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseLimit reads "30/1m": a burst of 30, refilled at 30 tokens per minute
+func ParseLimit(s string) (Limit, error) {
+	n, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, want <requests>/<duration>", s)
+	}
+	burst, err := strconv.Atoi(n)
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive integer", s)
+	}
+	period, err := time.ParseDuration(per)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad duration", s)
+	}
+	return Limit{Burst: burst, Period: period}, nil
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Burst) + "/" + l.Period.String()
+}
+
+// rate is the refill speed in tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / l.Period.Seconds()
+}
+
+// retryAfter is how long until tokens reaches one again
+func (l Limit) retryAfter(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / l.rate() * float64(time.Second)))
+}
+
+type Decision struct {
+	Allowed bool
+	// Remaining whole tokens after this request
+	Remaining int
+	// RetryAfter is set when the request was denied
+	RetryAfter time.Duration
+}
+
+// Limiter takes one token from the bucket named key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+}
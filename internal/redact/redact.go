@@ -0,0 +1,110 @@
+// Package redact wraps a slog.Handler so secrets never reach the log output: attributes with sensitive
+// keys are replaced wholesale and string values are scrubbed of known secret patterns.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces every removed value
+const Redacted = "[REDACTED]"
+
+// DefaultKeys are attribute keys whose value is always dropped, compared case-insensitively.
+// "code" is not among them because it is the apperr error code
+var DefaultKeys = []string{
+	"nonce", "state", "hmac", "signature",
+	"token", "access_token", "session", "cookie", "authorization",
+	"secret", "client_secret", "password",
+}
+
+// patterns catch secrets embedded in free text such as error strings, URLs and response bodies
+var patterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Shopify access tokens and app secrets: shpat_, shpca_, shppa_, shpss_, shpua_
+	{regexp.MustCompile(`shp(?:at|ca|pa|ss|ua)_[A-Za-z0-9]+`), Redacted},
+	// query strings: ...&code=...&hmac=...&state=...
+	{regexp.MustCompile(`(?i)\b(code|state|nonce|hmac|signature|access_token|client_secret)=[^&\s"']+`), "${1}=" + Redacted},
+	// JSON bodies: "access_token":"..."
+	{regexp.MustCompile(`(?i)"(code|state|nonce|hmac|access_token|client_secret)"\s*:\s*"[^"]*"`), `"${1}":"` + Redacted + `"`},
+}
+
+// String scrubs known secret patterns from s
+func String(s string) string {
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+type Handler struct {
+	next slog.Handler
+	keys map[string]struct{}
+}
+
+// NewHandler wraps next, dropping DefaultKeys plus extraKeys and scrubbing every string value
+func NewHandler(next slog.Handler, extraKeys ...string) *Handler {
+	keys := make(map[string]struct{}, len(DefaultKeys)+len(extraKeys))
+	for _, k := range append(append([]string{}, DefaultKeys...), extraKeys...) {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			keys[k] = struct{}{}
+		}
+	}
+	return &Handler{next: next, keys: keys}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{next: h.next.WithAttrs(h.attrs(attrs)), keys: h.keys}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), keys: h.keys}
+}
+
+func (h *Handler) attrs(in []slog.Attr) []slog.Attr {
+	out := make([]slog.Attr, len(in))
+	for i, a := range in {
+		out[i] = h.attr(a)
+	}
+	return out
+}
+
+func (h *Handler) attr(a slog.Attr) slog.Attr {
+	if _, ok := h.keys[strings.ToLower(a.Key)]; ok {
+		return slog.String(a.Key, Redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, String(v.String()))
+	case slog.KindGroup:
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(h.attrs(v.Group())...)}
+	case slog.KindAny:
+		// errors and arbitrary values are logged via their text form, scrub that instead
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, String(err.Error()))
+		}
+		if s, ok := v.Any().(interface{ String() string }); ok {
+			return slog.String(a.Key, String(s.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
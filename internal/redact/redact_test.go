@@ -0,0 +1,49 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	cases := map[string]string{
+		"token shpat_abc123 rejected":                      "token [REDACTED] rejected",
+		"/auth/callback?code=xyz&hmac=deadbeef&shop=a.com": "/auth/callback?code=[REDACTED]&hmac=[REDACTED]&shop=a.com",
+		`{"access_token":"tok","scope":"read_products"}`:   `{"access_token":"[REDACTED]","scope":"read_products"}`,
+		"shopify returned status 400: invalid_request":     "shopify returned status 400: invalid_request",
+		"state=abc nonce=def":                              "state=[REDACTED] nonce=[REDACTED]",
+		`{"client_secret": "s3cr3t", "code": "auth-code"}`: `{"client_secret":"[REDACTED]", "code":"[REDACTED]"}`,
+	}
+	for in, want := range cases {
+		if got := String(in); got != want {
+			t.Errorf("String(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), "X-Custom-Secret")).
+		With("nonce", "n-123")
+
+	log.WithGroup("req").Info("token exchange failed",
+		"shop", "a.myshopify.com",
+		"State", "s-456",
+		"x-custom-secret", "c-789",
+		"err", errors.New(`shopify returned status 400: {"access_token":"shpat_zzz"}`),
+		slog.Group("query", "hmac", "h-000", "url", "/login?code=k-111"),
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"n-123", "s-456", "c-789", "shpat_zzz", "h-000", "k-111"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("%q leaked: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "req.shop=a.myshopify.com") {
+		t.Fatalf("non-secret attributes must be kept: %s", out)
+	}
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ShopState is where an installation is in its lifecycle. Every change goes through CanTransitionTo
+type ShopState string
+
+const (
+	// StateInstalling: the OAuth callback was accepted, the access token is not stored yet
+	StateInstalling ShopState = "installing"
+	// StateActive: installed with a working access token
+	StateActive ShopState = "active"
+	// StateFrozen: installed, but the shop's plan is unpaid and Shopify froze it
+	StateFrozen ShopState = "frozen"
+	// StateNeedsReauth: installed, but Shopify rejects the stored token; the merchant must go through OAuth again
+	StateNeedsReauth ShopState = "needs_reauth"
+	// StateUninstalled: the app was removed, the token is gone, the row is kept for history
+	StateUninstalled ShopState = "uninstalled"
+	// StateRedacted: shop/redact erased the shop's data after an uninstall
+	StateRedacted ShopState = "redacted"
+)
+
+// transitions lists the states each state may move to. Staying in the same state is not a transition
+var transitions = map[ShopState][]ShopState{
+	StateInstalling:  {StateActive, StateUninstalled},
+	StateActive:      {StateFrozen, StateNeedsReauth, StateUninstalled},
+	StateFrozen:      {StateActive, StateNeedsReauth, StateUninstalled},
+	StateNeedsReauth: {StateActive, StateFrozen, StateUninstalled},
+	StateUninstalled: {StateInstalling, StateRedacted},
+	StateRedacted:    {StateInstalling},
+}
+
+// ErrInvalidTransition is matched by every *TransitionError
+var ErrInvalidTransition = errors.New("invalid shop state transition")
+
+// TransitionError reports a state change the lifecycle does not allow
+type TransitionError struct {
+	From ShopState
+	To   ShopState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid shop state transition from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// Valid reports whether s is one of the known states
+func (s ShopState) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether the lifecycle allows moving from s to next
+func (s ShopState) CanTransitionTo(next ShopState) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Installed reports whether the app is still installed on the shop, whatever the token's health
+func (s ShopState) Installed() bool {
+	return s == StateActive || s == StateFrozen || s == StateNeedsReauth
+}
+
+// HasToken reports whether the stored access token is expected to work
+func (s ShopState) HasToken() bool {
+	return s == StateActive || s == StateFrozen
+}
+
+// checkTransition returns a *TransitionError unless from may move to to
+func checkTransition(from, to ShopState) error {
+	if !from.CanTransitionTo(to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShopState_Transitions(t *testing.T) {
+	tests := []struct {
+		from, to ShopState
+		ok       bool
+	}{
+		{StateInstalling, StateActive, true},
+		{StateInstalling, StateFrozen, false},
+		{StateActive, StateFrozen, true},
+		{StateActive, StateNeedsReauth, true},
+		{StateActive, StateUninstalled, true},
+		{StateActive, StateRedacted, false},
+		{StateActive, StateInstalling, false},
+		{StateFrozen, StateActive, true},
+		{StateNeedsReauth, StateActive, true},
+		{StateUninstalled, StateInstalling, true},
+		{StateUninstalled, StateActive, false},
+		{StateUninstalled, StateRedacted, true},
+		{StateRedacted, StateInstalling, true},
+		{StateRedacted, StateUninstalled, false},
+		{ShopState("deleted"), StateActive, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.ok {
+			t.Errorf("%s -> %s: got %v, want %v", tt.from, tt.to, got, tt.ok)
+		}
+		err := checkTransition(tt.from, tt.to)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s -> %s: unexpected error %v", tt.from, tt.to, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s -> %s: error %v is not ErrInvalidTransition", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestShopState_Predicates(t *testing.T) {
+	for _, s := range []ShopState{StateInstalling, StateActive, StateFrozen, StateNeedsReauth, StateUninstalled, StateRedacted} {
+		if !s.Valid() {
+			t.Errorf("%s must be valid", s)
+		}
+	}
+	if ShopState("deleted").Valid() {
+		t.Error("unknown state must not be valid")
+	}
+	if !StateFrozen.Installed() || StateUninstalled.Installed() || StateInstalling.Installed() {
+		t.Error("unexpected Installed")
+	}
+	if !StateFrozen.HasToken() || StateNeedsReauth.HasToken() || StateUninstalled.HasToken() {
+		t.Error("unexpected HasToken")
+	}
+}
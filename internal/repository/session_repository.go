@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Session struct {
+	ID string
+	// APIKey is the app the session was opened for
+	APIKey     string
+	ShopDomain string
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+type SessionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSessionRepository(pool *pgxpool.Pool) *SessionRepository {
+	return &SessionRepository{pool: pool}
+}
+
+// Create stores a new session, the id is generated by the caller and is what the cookie references
+func (r *SessionRepository) Create(ctx context.Context, s Session) error {
+	const q = `
+INSERT INTO sessions (id, api_key, shop_domain, ip, user_agent, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+`
+	_, err := r.pool.Exec(ctx, q, s.ID, s.APIKey, s.ShopDomain, s.IP, s.UserAgent, s.ExpiresAt)
+	return err
+}
+
+// GetActive returns the session if it exists, is not revoked and has not expired
+func (r *SessionRepository) GetActive(ctx context.Context, id string) (*Session, error) {
+	const q = `
+SELECT id, api_key, shop_domain, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at
+FROM sessions
+WHERE id = $1
+  AND revoked_at IS NULL
+  AND expires_at > NOW()
+LIMIT 1;
+`
+	var s Session
+	err := r.pool.QueryRow(ctx, q, id).Scan(
+		&s.ID, &s.APIKey, &s.ShopDomain, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Touch records activity on a session
+func (r *SessionRepository) Touch(ctx context.Context, id string) error {
+	const q = `
+UPDATE sessions
+SET last_seen_at = NOW()
+WHERE id = $1;
+`
+	_, err := r.pool.Exec(ctx, q, id)
+	return err
+}
+
+// Revoke invalidates a single session (logout). Revoking an unknown or already revoked session is not an error
+func (r *SessionRepository) Revoke(ctx context.Context, id string) error {
+	const q = `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL;
+`
+	_, err := r.pool.Exec(ctx, q, id)
+	return err
+}
+
+// RevokeAllForShop invalidates every active session of a shop in app apiKey and returns how many were revoked
+func (r *SessionRepository) RevokeAllForShop(ctx context.Context, apiKey, shopDomain string) (int64, error) {
+	const q = `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE api_key = $1
+  AND shop_domain = $2
+  AND revoked_at IS NULL;
+`
+	tag, err := r.pool.Exec(ctx, q, apiKey, shopDomain)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListByShop returns the non-expired sessions of a shop in app apiKey, newest first, including revoked ones
+func (r *SessionRepository) ListByShop(ctx context.Context, apiKey, shopDomain string) ([]Session, error) {
+	const q = `
+SELECT id, api_key, shop_domain, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at
+FROM sessions
+WHERE api_key = $1
+  AND shop_domain = $2
+  AND expires_at > NOW()
+ORDER BY created_at DESC;
+`
+	rows, err := r.pool.Query(ctx, q, apiKey, shopDomain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(
+			&s.ID, &s.APIKey, &s.ShopDomain, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
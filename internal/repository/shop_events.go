@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// EventType is what happened to an installation
+type EventType string
+
+const (
+	// EventInstallStarted: OAuth began for a new or uninstalled shop
+	EventInstallStarted EventType = "install_started"
+	// EventInstalled: the first install completed
+	EventInstalled EventType = "installed"
+	// EventReinstalled: an install completed after an uninstall
+	EventReinstalled EventType = "reinstalled"
+	// EventReauthorized: an installed shop went through OAuth again and its token was replaced
+	EventReauthorized EventType = "reauthorized"
+	// EventScopesChanged: the granted scopes were rewritten outside OAuth
+	EventScopesChanged EventType = "scopes_changed"
+	EventFrozen        EventType = "frozen"
+	EventUnfrozen      EventType = "unfrozen"
+	// EventReauthRequired: Shopify rejected the stored token
+	EventReauthRequired EventType = "reauth_required"
+	EventUninstalled    EventType = "uninstalled"
+	EventRedacted       EventType = "redacted"
+)
+
+// stateEvents is the event Transition records when a shop enters a state. Active is only reached
+// through Transition from frozen
+var stateEvents = map[ShopState]EventType{
+	StateActive:      EventUnfrozen,
+	StateFrozen:      EventFrozen,
+	StateNeedsReauth: EventReauthRequired,
+	StateUninstalled: EventUninstalled,
+	StateRedacted:    EventRedacted,
+}
+
+// actor names recorded with events. Webhooks record WebhookActor(topic), workers WorkerActor(name)
+const (
+	ActorSystem   = "system"
+	ActorMerchant = "merchant"
+)
+
+// WebhookActor is the actor of a change made by a Shopify webhook
+func WebhookActor(topic string) string {
+	return "webhook:" + topic
+}
+
+// WorkerActor is the actor of a change made by a background worker
+func WorkerActor(name string) string {
+	return "worker:" + name
+}
+
+// Actor is who caused a change, recorded with its event. IP is empty for workers
+type Actor struct {
+	Name string
+	IP   string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx whose repository writes are attributed to a
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFrom returns the actor stored in ctx, ActorSystem when there is none
+func ActorFrom(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok && a.Name != "" {
+		return a
+	}
+	return Actor{Name: ActorSystem}
+}
+
+// ShopEvent is one entry of an installation's history. Metadata never holds tokens; IP and metadata are
+// blanked when the shop is redacted
+type ShopEvent struct {
+	ID         int64
+	APIKey     string
+	ShopDomain string
+	Type       EventType
+	Actor      string
+	IP         string
+	Metadata   map[string]any
+	CreatedAt  time.Time
+}
+
+// MaxTimeline caps the number of events Timeline returns at once
+const MaxTimeline = 200
+
+// recordEvent appends an event about s inside the transaction that changed it, attributed to the
+// actor of ctx
+func recordEvent(ctx context.Context, tx pgx.Tx, s *Shop, typ EventType, meta map[string]any) error {
+	if meta == nil {
+		meta = map[string]any{}
+	}
+	a := ActorFrom(ctx)
+	const q = `
+INSERT INTO shop_events (api_key, shop_domain, type, actor, ip, metadata)
+VALUES ($1, $2, $3, $4, $5, $6);
+`
+	_, err := tx.Exec(ctx, q, s.APIKey, s.ShopDomain, typ, a.Name, a.IP, meta)
+	return err
+}
+
+// scrubEvents erases the IP addresses and metadata (scopes, plans) of every event of s, inside the
+// transaction that redacts it. Type, actor and time are kept so the history still reads
+func scrubEvents(ctx context.Context, tx pgx.Tx, s *Shop) error {
+	const q = `
+UPDATE shop_events
+SET ip = '',
+    metadata = '{}'
+WHERE api_key = $1
+  AND shop_domain = $2
+  AND (ip <> '' OR metadata <> '{}');
+`
+	_, err := tx.Exec(ctx, q, s.APIKey, s.ShopDomain)
+	return err
+}
+
+// Timeline returns the newest events of an installation, newest first. before is the id of the
+// oldest event of the previous page, 0 starts at the newest. limit is capped at MaxTimeline
+func (r *ShopRepository) Timeline(ctx context.Context, apiKey, shopDomain string, before int64, limit int) (_ []ShopEvent, err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.Timeline", shopDomain)
+	defer func() { endSpan(span, err) }()
+
+	if limit <= 0 || limit > MaxTimeline {
+		limit = MaxTimeline
+	}
+	const q = `
+SELECT id, api_key, shop_domain, type, actor, ip, metadata, created_at
+FROM shop_events
+WHERE api_key = $1
+  AND shop_domain = $2
+  AND ($3::bigint = 0 OR id < $3)
+ORDER BY id DESC
+LIMIT $4;
+`
+	rows, err := r.pool.Query(ctx, q, apiKey, shopDomain, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []ShopEvent
+	for rows.Next() {
+		var e ShopEvent
+		if err := rows.Scan(&e.ID, &e.APIKey, &e.ShopDomain, &e.Type, &e.Actor, &e.IP, &e.Metadata, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
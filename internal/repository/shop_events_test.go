@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func TestShopEvents_WrittenWithChanges(t *testing.T) {
+	_ = godotenv.Load("../../.env")
+	if os.Getenv("TEST_DATABASE_URL") == "" && os.Getenv("DATABASE_URL") == "" {
+		t.Skip("set TEST_DATABASE_URL or DATABASE_URL")
+	}
+	pool := mustPool(t)
+	repo := NewShopRepository(pool)
+
+	apiKey := "unit-test-key"
+	// events are append-only, every run uses its own shop
+	shop := fmt.Sprintf("events-%d.myshopify.com", time.Now().UnixNano())
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DELETE FROM shops WHERE api_key = $1 AND shop_domain = $2", apiKey, shop)
+	})
+
+	merchant := WithActor(context.Background(), Actor{Name: ActorMerchant, IP: "192.0.2.1"})
+	webhook := WithActor(context.Background(), Actor{Name: WebhookActor("app/uninstalled")})
+
+	steps := []func() error{
+		func() error { _, err := repo.BeginInstall(merchant, apiKey, shop); return err },
+		func() error { _, err := repo.Install(merchant, apiKey, shop, "shpat_one", "read_products"); return err },
+		func() error {
+			return repo.UpdateScopes(context.Background(), apiKey, shop, "read_products,read_orders")
+		},
+		func() error { _, err := repo.Transition(webhook, apiKey, shop, StateUninstalled); return err },
+		// a repeated change leaves no trace
+		func() error { _, err := repo.Transition(webhook, apiKey, shop, StateUninstalled); return err },
+		func() error { _, err := repo.BeginInstall(merchant, apiKey, shop); return err },
+		func() error { _, err := repo.Install(merchant, apiKey, shop, "shpat_two", "read_products"); return err },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if _, err := repo.Transition(webhook, apiKey, shop, StateRedacted); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("expected invalid transition, got %v", err)
+	}
+
+	events, err := repo.Timeline(context.Background(), apiKey, shop, 0, 0)
+	if err != nil {
+		t.Fatalf("timeline: %v", err)
+	}
+	var got []EventType
+	for _, e := range events {
+		got = append(got, e.Type)
+	}
+	want := []EventType{EventReinstalled, EventInstallStarted, EventUninstalled, EventScopesChanged, EventInstalled, EventInstallStarted}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected timeline %v, want %v", got, want)
+	}
+	if e := events[0]; e.Actor != ActorMerchant || e.IP != "192.0.2.1" || e.Metadata["reinstall_count"] != float64(1) {
+		t.Fatalf("unexpected reinstall event %+v", e)
+	}
+	if e := events[2]; e.Actor != "webhook:app/uninstalled" || e.Metadata["from"] != string(StateActive) {
+		t.Fatalf("unexpected uninstall event %+v", e)
+	}
+	if e := events[3]; e.Actor != ActorSystem || e.Metadata["previous"] != "read_products" {
+		t.Fatalf("unexpected scopes event %+v", e)
+	}
+
+	page, err := repo.Timeline(context.Background(), apiKey, shop, events[1].ID, 2)
+	if err != nil || len(page) != 2 || page[0].ID != events[2].ID {
+		t.Fatalf("unexpected page %+v, %v", page, err)
+	}
+
+	if _, err := pool.Exec(context.Background(), "UPDATE shop_events SET actor = 'x' WHERE shop_domain = $1", shop); err == nil {
+		t.Fatal("shop_events must reject updates")
+	}
+}
+
+func TestShopEvents_RedactScrubsIPAndMetadata(t *testing.T) {
+	_ = godotenv.Load("../../.env")
+	if os.Getenv("TEST_DATABASE_URL") == "" && os.Getenv("DATABASE_URL") == "" {
+		t.Skip("set TEST_DATABASE_URL or DATABASE_URL")
+	}
+	pool := mustPool(t)
+	repo := NewShopRepository(pool)
+	ctx := context.Background()
+
+	apiKey := "unit-test-key"
+	shop := fmt.Sprintf("redact-%d.myshopify.com", time.Now().UnixNano())
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DELETE FROM shops WHERE api_key = $1 AND shop_domain = $2", apiKey, shop)
+	})
+
+	merchant := WithActor(ctx, Actor{Name: ActorMerchant, IP: "192.0.2.1"})
+	if _, err := repo.Install(merchant, apiKey, shop, "shpat_one", "read_products"); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if err := repo.UpdateScopes(merchant, apiKey, shop, "read_products,read_orders"); err != nil {
+		t.Fatalf("update scopes: %v", err)
+	}
+	if _, err := repo.Transition(WithActor(ctx, Actor{Name: WebhookActor("app/uninstalled"), IP: "198.51.100.1"}), apiKey, shop, StateUninstalled); err != nil {
+		t.Fatalf("uninstall: %v", err)
+	}
+	if _, err := repo.Transition(WithActor(ctx, Actor{Name: WebhookActor("shop/redact"), IP: "198.51.100.1"}), apiKey, shop, StateRedacted); err != nil {
+		t.Fatalf("redact: %v", err)
+	}
+
+	events, err := repo.Timeline(ctx, apiKey, shop, 0, 0)
+	if err != nil {
+		t.Fatalf("timeline: %v", err)
+	}
+	want := []EventType{EventRedacted, EventUninstalled, EventScopesChanged, EventInstalled}
+	if len(events) != len(want) {
+		t.Fatalf("unexpected timeline %+v", events)
+	}
+	for i, e := range events {
+		if e.Type != want[i] || e.IP != "" || len(e.Metadata) != 0 {
+			t.Fatalf("event %d not anonymized: %+v", i, e)
+		}
+	}
+	// who did what, and when, survives
+	if events[1].Actor != "webhook:app/uninstalled" || events[3].Actor != ActorMerchant {
+		t.Fatalf("actors lost: %+v", events)
+	}
+
+	// a late app/scopes_update neither restores scopes nor records their values again
+	if err := repo.UpdateScopes(ctx, apiKey, shop, "read_products,read_orders"); err != ErrNotFound {
+		t.Fatalf("update scopes of a redacted shop: got %v, want ErrNotFound", err)
+	}
+	if s, err := repo.GetByDomain(ctx, apiKey, shop); err != nil || s.Scopes != "" {
+		t.Fatalf("redacted shop got scopes back: %+v, %v", s, err)
+	}
+	if after, err := repo.Timeline(ctx, apiKey, shop, 0, 0); err != nil || len(after) != len(events) {
+		t.Fatalf("scopes update recorded an event on a redacted shop: %+v, %v", after, err)
+	}
+
+	// anonymizing is the only rewrite the trigger allows
+	if _, err := pool.Exec(ctx, "UPDATE shop_events SET ip = '192.0.2.9' WHERE shop_domain = $1", shop); err == nil {
+		t.Fatal("shop_events must reject rewriting an IP")
+	}
+	if _, err := pool.Exec(ctx, "DELETE FROM shop_events WHERE shop_domain = $1", shop); err == nil {
+		t.Fatal("shop_events must reject deletes")
+	}
+}
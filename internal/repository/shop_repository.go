@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -12,14 +13,99 @@ import (
 var ErrNotFound = errors.New("not found")
 
 type Shop struct {
-	ID                 int64
+	ID int64
+	// APIKey is the app the shop installed; the same shop has one row per app
+	APIKey             string
 	ShopDomain         string
 	OfflineAccessToken string
 	Scopes             string
-	InstalledAt        time.Time
-	UpdatedAt          time.Time
+	// InstalledAt is when the latest install completed, FirstInstalledAt survives uninstalls
+	InstalledAt time.Time
+	UpdatedAt   time.Time
+
+	State            ShopState
+	StateChangedAt   time.Time
+	FirstInstalledAt time.Time
+	ReinstallCount   int
+	UninstalledAt    *time.Time
+
+	// ShopMetadata is synced from Shopify after install, MetadataSyncedAt is nil until the first sync
+	ShopMetadata
+	MetadataSyncedAt *time.Time
+}
+
+// ShopMetadata mirrors Shopify's shop object for support: who owns the shop, its plan and locale
+type ShopMetadata struct {
+	Name          string
+	Email         string
+	ContactEmail  string
+	PlanName      string
+	CurrencyCode  string
+	IanaTimezone  string
+	PrimaryDomain string
+}
+
+// ScopeList returns the granted scopes as a slice, dropping empty entries
+func (s *Shop) ScopeList() []string {
+	return SplitScopes(s.Scopes)
+}
+
+// HasScope reports whether scope is currently granted. A write_ scope implies its read_ counterpart
+func (s *Shop) HasScope(scope string) bool {
+	scope = strings.TrimSpace(scope)
+	if scope == "" {
+		return false
+	}
+	implied := ""
+	if rest, ok := strings.CutPrefix(scope, "read_"); ok {
+		implied = "write_" + rest
+	}
+	for _, granted := range s.ScopeList() {
+		if granted == scope || (implied != "" && granted == implied) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitScopes parses Shopify's comma separated scope string
+func SplitScopes(scopes string) []string {
+	var out []string
+	for _, s := range strings.Split(scopes, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// JoinScopes is the inverse of SplitScopes
+func JoinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+// shopColumns is the column list every query returning a Shop selects, in scanShop order
+const shopColumns = `id, api_key, shop_domain, offline_access_token, scopes, installed_at, updated_at,
+  shop_name, email, contact_email, plan_name, currency_code, iana_timezone, primary_domain, metadata_synced_at,
+  state, state_changed_at, first_installed_at, reinstall_count, uninstalled_at`
+
+func scanShop(row pgx.Row) (*Shop, error) {
+	var s Shop
+	err := row.Scan(
+		&s.ID, &s.APIKey, &s.ShopDomain, &s.OfflineAccessToken, &s.Scopes, &s.InstalledAt, &s.UpdatedAt,
+		&s.Name, &s.Email, &s.ContactEmail, &s.PlanName, &s.CurrencyCode, &s.IanaTimezone, &s.PrimaryDomain,
+		&s.MetadataSyncedAt,
+		&s.State, &s.StateChangedAt, &s.FirstInstalledAt, &s.ReinstallCount, &s.UninstalledAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
 }
 
+// ShopRepository stores installations. Every lifecycle, token and scope change is written in one
+// transaction with its shop_events entry, see Timeline
 type ShopRepository struct {
 	pool *pgxpool.Pool
 }
@@ -28,48 +114,304 @@ func NewShopRepository(pool *pgxpool.Pool) *ShopRepository {
 	return &ShopRepository{pool: pool}
 }
 
-// GetByDomain retrieves a shop by its domain from the database
-func (r *ShopRepository) GetByDomain(ctx context.Context, shopDomain string) (*Shop, error) {
+// GetByDomain retrieves the installation of app apiKey on a shop
+func (r *ShopRepository) GetByDomain(ctx context.Context, apiKey, shopDomain string) (_ *Shop, err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.GetByDomain", shopDomain)
+	defer func() { endSpan(span, err) }()
+
 	const q = `
-SELECT id, shop_domain, offline_access_token, scopes, installed_at, updated_at
+SELECT ` + shopColumns + `
 FROM shops
-WHERE shop_domain = $1
+WHERE api_key = $1
+  AND shop_domain = $2
 LIMIT 1;
 `
-	var s Shop
-	err := r.pool.QueryRow(ctx, q, shopDomain).Scan(
-		&s.ID,
-		&s.ShopDomain,
-		&s.OfflineAccessToken,
-		&s.Scopes,
-		&s.InstalledAt,
-		&s.UpdatedAt,
-	)
+	s, err := scanShop(r.pool.QueryRow(ctx, q, apiKey, shopDomain))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	return &s, nil
+	return s, nil
+}
+
+// BeginInstall records that the OAuth callback for a shop was accepted: a new row or an uninstalled one
+// moves to installing. Installed shops going through OAuth again (re-authorization) are left as they are
+func (r *ShopRepository) BeginInstall(ctx context.Context, apiKey, shopDomain string) (_ *Shop, err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.BeginInstall", shopDomain)
+	defer func() { endSpan(span, err) }()
+
+	var out *Shop
+	err = pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		cur, err := lockShop(ctx, tx, apiKey, shopDomain)
+		if err == ErrNotFound {
+			const q = `
+INSERT INTO shops (api_key, shop_domain, offline_access_token, scopes, state)
+VALUES ($1, $2, '', '', $3)
+RETURNING ` + shopColumns + `;
+`
+			if out, err = scanShop(tx.QueryRow(ctx, q, apiKey, shopDomain, StateInstalling)); err != nil {
+				return err
+			}
+			return recordEvent(ctx, tx, out, EventInstallStarted, nil)
+		}
+		if err != nil {
+			return err
+		}
+		if cur.State.Installed() || cur.State == StateInstalling {
+			out = cur
+			return nil
+		}
+		if out, err = setState(ctx, tx, cur, StateInstalling); err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, out, EventInstallStarted, map[string]any{"from": cur.State})
+	})
+	return out, err
 }
 
-// upsert inserts a new shop or updates existing shop's token and scopes
-func (r *ShopRepository) Upsert(ctx context.Context, shopDomain, token, scopes string) (*Shop, error) {
+// Install stores the offline token of a completed OAuth flow. An installing shop becomes active, keeping
+// first_installed_at and counting the reinstall when it had been uninstalled before; a needs_reauth shop
+// becomes active again. A frozen shop stays frozen, a new token does not pay the bill
+func (r *ShopRepository) Install(ctx context.Context, apiKey, shopDomain, token, scopes string) (_ *Shop, err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.Install", shopDomain)
+	defer func() { endSpan(span, err) }()
+
+	var out *Shop
+	err = pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		cur, err := lockShop(ctx, tx, apiKey, shopDomain)
+		if err == ErrNotFound {
+			const q = `
+INSERT INTO shops (api_key, shop_domain, offline_access_token, scopes, state)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING ` + shopColumns + `;
+`
+			if out, err = scanShop(tx.QueryRow(ctx, q, apiKey, shopDomain, token, scopes, StateActive)); err != nil {
+				return err
+			}
+			return recordEvent(ctx, tx, out, EventInstalled, map[string]any{"scopes": scopes})
+		}
+		if err != nil {
+			return err
+		}
+
+		next := StateActive
+		if cur.State == StateFrozen {
+			next = StateFrozen
+		}
+		if cur.State != next {
+			if err := checkTransition(cur.State, next); err != nil {
+				return err
+			}
+		}
+		completing := cur.State == StateInstalling
+		reinstall := 0
+		if completing && cur.UninstalledAt != nil && cur.UninstalledAt.After(cur.InstalledAt) {
+			reinstall = 1
+		}
+
+		const q = `
+UPDATE shops
+SET offline_access_token = $2,
+    scopes = $3,
+    state = $4,
+    state_changed_at = CASE WHEN state = $4 THEN state_changed_at ELSE NOW() END,
+    installed_at = CASE WHEN $5 THEN NOW() ELSE installed_at END,
+    reinstall_count = reinstall_count + $6,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING ` + shopColumns + `;
+`
+		if out, err = scanShop(tx.QueryRow(ctx, q, cur.ID, token, scopes, next, completing, reinstall)); err != nil {
+			return err
+		}
+
+		event, meta := EventReauthorized, map[string]any{"from": cur.State, "scopes": scopes}
+		switch {
+		case reinstall == 1:
+			event, meta["reinstall_count"] = EventReinstalled, out.ReinstallCount
+		case completing:
+			event = EventInstalled
+		}
+		if !completing && cur.Scopes != scopes {
+			meta["previous_scopes"] = cur.Scopes
+		}
+		return recordEvent(ctx, tx, out, event, meta)
+	})
+	return out, err
+}
+
+// Transition moves an installation to another state without a new token: frozen and back, needs_reauth,
+// uninstalled (the token is dropped) or redacted (the shop's data is erased, and so are the IPs and
+// metadata of its events). Repeating the current state is a no-op so retried webhooks succeed. Installing
+// and re-activating a shop without a working token go through BeginInstall and Install instead
+func (r *ShopRepository) Transition(ctx context.Context, apiKey, shopDomain string, to ShopState) (_ *Shop, err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.Transition", shopDomain)
+	defer func() { endSpan(span, err) }()
+
+	var out *Shop
+	err = pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		cur, err := lockShop(ctx, tx, apiKey, shopDomain)
+		if err != nil {
+			return err
+		}
+		if cur.State == to {
+			out = cur
+			return nil
+		}
+		if to == StateInstalling || (to == StateActive && !cur.State.HasToken()) {
+			return &TransitionError{From: cur.State, To: to}
+		}
+		if out, err = setState(ctx, tx, cur, to); err != nil {
+			return err
+		}
+		if err := recordEvent(ctx, tx, out, stateEvents[to], map[string]any{"from": cur.State}); err != nil {
+			return err
+		}
+		if to == StateRedacted {
+			// the redacted event included, no event of the shop keeps an IP or metadata
+			return scrubEvents(ctx, tx, out)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// lockShop reads an installation and locks its row until tx ends
+func lockShop(ctx context.Context, tx pgx.Tx, apiKey, shopDomain string) (*Shop, error) {
 	const q = `
-INSERT INTO shops (shop_domain, offline_access_token, scopes)
-VALUES ($1, $2, $3)
-ON CONFLICT (shop_domain) DO UPDATE
-SET offline_access_token = EXCLUDED.offline_access_token,
-    scopes = EXCLUDED.scopes,
+SELECT ` + shopColumns + `
+FROM shops
+WHERE api_key = $1
+  AND shop_domain = $2
+FOR UPDATE;
+`
+	s, err := scanShop(tx.QueryRow(ctx, q, apiKey, shopDomain))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return s, err
+}
+
+// stateEffects are the columns entering a state rewrites besides state and state_changed_at
+var stateEffects = map[ShopState]string{
+	StateUninstalled: `,
+    offline_access_token = '',
+    uninstalled_at = NOW()`,
+	StateRedacted: `,
+    offline_access_token = '',
+    scopes = '',
+    shop_name = '',
+    email = '',
+    contact_email = '',
+    plan_name = '',
+    currency_code = '',
+    iana_timezone = '',
+    primary_domain = '',
+    metadata_synced_at = NULL`,
+}
+
+// setState validates and applies one transition of a row locked by lockShop
+func setState(ctx context.Context, tx pgx.Tx, cur *Shop, to ShopState) (*Shop, error) {
+	if err := checkTransition(cur.State, to); err != nil {
+		return nil, err
+	}
+	q := `
+UPDATE shops
+SET state = $2,
+    state_changed_at = NOW()` + stateEffects[to] + `
+WHERE id = $1
+RETURNING ` + shopColumns + `;
+`
+	return scanShop(tx.QueryRow(ctx, q, cur.ID, to))
+}
+
+// UpdateScopes overwrites the stored scopes of an installed shop and records the change. A shop
+// that is not installed reads as ErrNotFound: a late app/scopes_update must not bring back scopes
+// that an uninstall or a redaction cleared
+func (r *ShopRepository) UpdateScopes(ctx context.Context, apiKey, shopDomain, scopes string) (err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.UpdateScopes", shopDomain)
+	defer func() { endSpan(span, err) }()
+
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		cur, err := lockShop(ctx, tx, apiKey, shopDomain)
+		if err != nil {
+			return err
+		}
+		if !cur.State.Installed() {
+			return ErrNotFound
+		}
+
+		const q = `
+UPDATE shops
+SET scopes = $2,
     updated_at = NOW()
-RETURNING id, shop_domain, offline_access_token, scopes, installed_at, updated_at;
+WHERE id = $1;
 `
-	var s Shop
-	if err := r.pool.QueryRow(ctx, q, shopDomain, token, scopes).Scan(
-		&s.ID, &s.ShopDomain, &s.OfflineAccessToken, &s.Scopes, &s.InstalledAt, &s.UpdatedAt,
-	); err != nil {
+		if _, err := tx.Exec(ctx, q, cur.ID, scopes); err != nil {
+			return err
+		}
+		if cur.Scopes == scopes {
+			return nil
+		}
+		return recordEvent(ctx, tx, cur, EventScopesChanged, map[string]any{"previous": cur.Scopes, "current": scopes})
+	})
+}
+
+// UpdateMetadata stores the shop's metadata and marks it synced. It does not touch updated_at,
+// which tracks the installation itself
+func (r *ShopRepository) UpdateMetadata(ctx context.Context, apiKey, shopDomain string, m ShopMetadata) (err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.UpdateMetadata", shopDomain)
+	defer func() { endSpan(span, err) }()
+
+	const q = `
+UPDATE shops
+SET shop_name = $3,
+    email = $4,
+    contact_email = $5,
+    plan_name = $6,
+    currency_code = $7,
+    iana_timezone = $8,
+    primary_domain = $9,
+    metadata_synced_at = NOW()
+WHERE api_key = $1
+  AND shop_domain = $2;
+`
+	tag, err := r.pool.Exec(ctx, q, apiKey, shopDomain,
+		m.Name, m.Email, m.ContactEmail, m.PlanName, m.CurrencyCode, m.IanaTimezone, m.PrimaryDomain)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List returns every installation of every app ordered by id
+func (r *ShopRepository) List(ctx context.Context) (_ []Shop, err error) {
+	ctx, span := startSpan(ctx, "ShopRepository.List", "")
+	defer func() { endSpan(span, err) }()
+
+	const q = `
+SELECT ` + shopColumns + `
+FROM shops
+ORDER BY id;
+`
+	rows, err := r.pool.Query(ctx, q)
+	if err != nil {
 		return nil, err
 	}
-	return &s, nil
+	defer rows.Close()
+
+	var shops []Shop
+	for rows.Next() {
+		s, err := scanShop(rows)
+		if err != nil {
+			return nil, err
+		}
+		shops = append(shops, *s)
+	}
+	return shops, rows.Err()
 }
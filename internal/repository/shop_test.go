@@ -0,0 +1,26 @@
+package repository
+
+import "testing"
+
+func TestShopHasScope(t *testing.T) {
+	s := &Shop{Scopes: "read_orders, write_products,,read_customers"}
+
+	cases := map[string]bool{
+		"read_orders":     true,
+		"write_products":  true,
+		"read_products":   true, // implied by write_products
+		"read_customers":  true,
+		"write_customers": false,
+		"write_orders":    false,
+		"":                false,
+	}
+	for scope, want := range cases {
+		if got := s.HasScope(scope); got != want {
+			t.Errorf("HasScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+
+	if got := s.ScopeList(); len(got) != 3 {
+		t.Fatalf("expected 3 scopes, got %v", got)
+	}
+}
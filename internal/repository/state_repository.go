@@ -17,33 +17,43 @@ func NewStateRepository(pool *pgxpool.Pool) *StateRepository {
 	return &StateRepository{pool: pool}
 }
 
-// create stores the generated OAuth state nonce and computes expires_at using the provided TTL
-func (r *StateRepository) Create(ctx context.Context, shopDomain, nonce string, ttl time.Duration) error {
+// create stores the generated OAuth state nonce and computes expires_at using the provided TTL.
+// host is the decoded admin host the flow started from ("" if unknown), carried to the callback.
+// apiKey binds the state to the app whose callback may consume it
+func (r *StateRepository) Create(ctx context.Context, apiKey, shopDomain, nonce, host string, ttl time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "StateRepository.Create", shopDomain)
+	defer func() { endSpan(span, err) }()
+
 	expiresAt := time.Now().UTC().Add(ttl)
 
 	const q = `
-INSERT INTO oauth_states (shop_domain, nonce, expires_at)
-VALUES ($1, $2, $3);
+INSERT INTO oauth_states (api_key, shop_domain, nonce, host, expires_at)
+VALUES ($1, $2, $3, $4, $5);
 `
-	_, err := r.pool.Exec(ctx, q, shopDomain, nonce, expiresAt)
+	_, err = r.pool.Exec(ctx, q, apiKey, shopDomain, nonce, host, expiresAt)
 	return err
 }
 
-func (r *StateRepository) Consume(ctx context.Context, shopDomain, nonce string) (bool, error) {
+// Consume deletes a valid state and returns the host stored with it
+func (r *StateRepository) Consume(ctx context.Context, apiKey, shopDomain, nonce string) (_ string, _ bool, err error) {
+	ctx, span := startSpan(ctx, "StateRepository.Consume", shopDomain)
+	defer func() { endSpan(span, err) }()
+
 	const q = `
 DELETE FROM oauth_states
-WHERE shop_domain = $1
-  AND nonce = $2
+WHERE api_key = $1
+  AND shop_domain = $2
+  AND nonce = $3
   AND expires_at > NOW()
-RETURNING id;
+RETURNING host;
 `
-	var id int64
-	err := r.pool.QueryRow(ctx, q, shopDomain, nonce).Scan(&id)
+	var host string
+	err = r.pool.QueryRow(ctx, q, apiKey, shopDomain, nonce).Scan(&host)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return false, nil
+			return "", false, nil
 		}
-		return false, err
+		return "", false, err
 	}
-	return true, nil
+	return host, true, nil
 }
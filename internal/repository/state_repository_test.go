@@ -38,26 +38,30 @@ func TestStateConsume_SingleUseAndTTL(t *testing.T) {
 	repo := NewStateRepository(pool)
 	ctx := context.Background()
 
+	apiKey := "unit-test-key"
 	shop := "unit-test.myshopify.com"
 	nonce := "nonce-" + time.Now().Format("150405.000000000")
 
 	_, _ = pool.Exec(ctx, "DELETE FROM oauth_states WHERE shop_domain=$1 OR nonce=$2", shop, nonce)
 
-	if err := repo.Create(ctx, shop, nonce, 1*time.Minute); err != nil {
+	if err := repo.Create(ctx, apiKey, shop, nonce, "admin.shopify.com/store/unit-test", 1*time.Minute); err != nil {
 		t.Fatalf("create: %v", err)
 	}
 
 	// first consume: true
-	ok, err := repo.Consume(ctx, shop, nonce)
+	host, ok, err := repo.Consume(ctx, apiKey, shop, nonce)
 	if err != nil {
 		t.Fatalf("consume1 err: %v", err)
 	}
 	if !ok {
 		t.Fatalf("expected consume1 ok=true")
 	}
+	if host != "admin.shopify.com/store/unit-test" {
+		t.Fatalf("expected host to round trip, got %q", host)
+	}
 
 	// second consume: false (single-use)
-	ok, err = repo.Consume(ctx, shop, nonce)
+	_, ok, err = repo.Consume(ctx, apiKey, shop, nonce)
 	if err != nil {
 		t.Fatalf("consume2 err: %v", err)
 	}
@@ -65,13 +69,23 @@ func TestStateConsume_SingleUseAndTTL(t *testing.T) {
 		t.Fatalf("expected consume2 ok=false")
 	}
 
+	// a state is bound to the app that created it
+	nonce3 := nonce + "-other-app"
+	_, _ = pool.Exec(ctx, "DELETE FROM oauth_states WHERE nonce=$1", nonce3)
+	if err := repo.Create(ctx, apiKey, shop, nonce3, "", 1*time.Minute); err != nil {
+		t.Fatalf("create other app: %v", err)
+	}
+	if _, ok, err := repo.Consume(ctx, "other-app-key", shop, nonce3); err != nil || ok {
+		t.Fatalf("another app consumed the state: ok=%v err=%v", ok, err)
+	}
+
 	// expired should not consume
 	nonce2 := nonce + "-expired"
 	_, _ = pool.Exec(ctx, "DELETE FROM oauth_states WHERE nonce=$1", nonce2)
-	if err := repo.Create(ctx, shop, nonce2, -1*time.Minute); err != nil {
+	if err := repo.Create(ctx, apiKey, shop, nonce2, "", -1*time.Minute); err != nil {
 		t.Fatalf("create expired: %v", err)
 	}
-	ok, err = repo.Consume(ctx, shop, nonce2)
+	_, ok, err = repo.Consume(ctx, apiKey, shop, nonce2)
 	if err != nil {
 		t.Fatalf("consume expired err: %v", err)
 	}
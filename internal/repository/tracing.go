@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"shopify-auth-app/internal/telemetry"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("shopify-auth-app/internal/repository")
+
+// startSpan opens a client span for one repository call. Only the shop is recorded, never
+// the other arguments: they include access tokens and nonces
+func startSpan(ctx context.Context, op, shopDomain string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{semconv.DBSystemNamePostgreSQL, semconv.DBOperationName(op)}
+	if shopDomain != "" {
+		attrs = append(attrs, telemetry.ShopDomain(shopDomain))
+	}
+	return tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan marks span failed unless err is nil or the expected ErrNotFound
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,281 @@
+package shopify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"regexp"
+	"shopify-auth-app/internal/logctx"
+	"shopify-auth-app/internal/telemetry"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("shopify-auth-app/internal/shopify")
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultUserAgent  = "shopify-auth-app"
+	defaultMaxRetries = 2
+	defaultBaseDelay  = 200 * time.Millisecond
+	defaultMaxDelay   = 2 * time.Second
+
+	// apiVersion is the Admin API version of GraphQL calls
+	apiVersion = "2025-10"
+)
+
+// Client talks to the Shopify Admin API on behalf of one app
+type Client struct {
+	apiKey    string
+	apiSecret string
+
+	baseURL    string
+	httpClient *http.Client
+	userAgent  string
+
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+
+	log     *slog.Logger
+	observe RequestObserver
+}
+
+// RequestObserver is told about every attempt: the normalized endpoint, the response status
+// (0 when none was received) and how long the attempt took
+type RequestObserver func(endpoint string, status int, d time.Duration)
+
+type ClientOption func(*Client)
+
+// WithBaseURL sends every request to baseURL instead of https://<shop>, used to point tests at a fake server
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithTransport replaces the underlying http.RoundTripper
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Transport = rt
+	}
+}
+
+// WithTimeout sets the per-attempt request timeout
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Timeout = d
+	}
+}
+
+func WithUserAgent(ua string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithRetries configures how many times transient failures are retried and the backoff bounds
+func WithRetries(maxRetries int, baseDelay, maxDelay time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.baseDelay = baseDelay
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithLogger sets the logger used when the request context carries none
+func WithLogger(l *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.log = l
+	}
+}
+
+// WithRequestObserver reports every attempt to fn, used for latency metrics
+func WithRequestObserver(fn RequestObserver) ClientOption {
+	return func(c *Client) {
+		c.observe = fn
+	}
+}
+
+func NewClient(apiKey, apiSecret string, opts ...ClientOption) *Client {
+	c := &Client{
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		userAgent:  defaultUserAgent,
+		maxRetries: defaultMaxRetries,
+		baseDelay:  defaultBaseDelay,
+		maxDelay:   defaultMaxDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) shopURL(shopDomain, path string) string {
+	if c.baseURL != "" {
+		return c.baseURL + path
+	}
+	return "https://" + shopDomain + path
+}
+
+// retryPolicy says which failed attempts of a call may be repeated
+type retryPolicy int
+
+const (
+	// retryIdempotent repeats network errors and 5xx responses: reads that are safe to send twice
+	retryIdempotent retryPolicy = iota
+	// retryUnsent only repeats attempts that never reached Shopify (dial errors). Calls with side effects,
+	// such as redeeming a single-use authorization code, must not be replayed after Shopify may have seen them
+	retryUnsent
+)
+
+// do sends the request to path on shopDomain, retrying the failures policy allows with jittered exponential
+// backoff. The body is buffered so it can be replayed on every attempt. One client span covers the whole
+// call, retries are recorded as span events
+func (c *Client) do(ctx context.Context, policy retryPolicy, shopDomain, method, path string, header http.Header, body []byte) (status int, respBody []byte, err error) {
+	ep := endpoint(path)
+	ctx, span := tracer.Start(ctx, method+" "+ep,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(method),
+			attribute.String("shopify.endpoint", ep),
+			telemetry.ShopDomain(shopDomain),
+		),
+	)
+	defer func() {
+		if status > 0 {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		span.End()
+	}()
+
+	log := logctx.From(ctx, c.log).With("method", method, "path", path)
+	rawURL := c.shopURL(shopDomain, path)
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		status, respBody, err = c.doOnce(ctx, method, rawURL, header, body)
+		elapsed := time.Since(start)
+		log.Debug("shopify request", "attempt", attempt, "status", status, "duration", elapsed, "err", err)
+		if c.observe != nil {
+			c.observe(ep, status, elapsed)
+		}
+		if err == nil && status < 500 {
+			return status, respBody, nil
+		}
+		if attempt >= c.maxRetries || !retryable(ctx, policy, err) {
+			return status, respBody, err
+		}
+		log.Warn("retrying shopify request", "attempt", attempt, "status", status, "err", err)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt), attribute.Int("status", status)))
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt + 1))
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, rawURL string, header http.Header, body []byte) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	// traceparent lets a tracing proxy or Shopify support correlate the call with our trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// statusError describes an unexpected response. Only Shopify's short error code is kept from the body:
+// the full body can echo the request, including the authorization code and client secret
+func statusError(status int, body []byte) error {
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" && len(payload.Error) <= 64 {
+		return fmt.Errorf("shopify returned status %d: %s", status, payload.Error)
+	}
+	return fmt.Errorf("shopify returned status %d", status)
+}
+
+// apiVersionRe matches the version segment of /admin/api/2025-01/...
+var apiVersionRe = regexp.MustCompile(`^/admin/api/[^/]+/`)
+
+// endpoint is the path with the API version folded, so metric labels and span names stay bounded across versions
+func endpoint(path string) string {
+	return apiVersionRe.ReplaceAllString(path, "/admin/api/{version}/")
+}
+
+// backoff returns a full-jitter delay for the given attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.baseDelay << attempt
+	if d <= 0 || d > c.maxDelay {
+		d = c.maxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
+
+// retryable reports whether a failed attempt may be repeated under policy. With retryIdempotent 5xx
+// responses (err == nil) always are and network errors are unless the caller's context is done.
+// With retryUnsent only dial errors are
+func retryable(ctx context.Context, policy retryPolicy, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if policy == retryUnsent {
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+	if err == nil {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,303 @@
+package shopify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"shopify-auth-app/internal/logctx"
+	"shopify-auth-app/internal/telemetry"
+	"shopify-auth-app/internal/telemetry/telemetrytest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestClient(srv *httptest.Server, opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithBaseURL(srv.URL),
+		WithRetries(2, time.Millisecond, 5*time.Millisecond),
+	}, opts...)
+	return NewClient("key", "secret", opts...)
+}
+
+func TestExchangeCodeForToken_OK(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/oauth/access_token" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("User-Agent"); got != "test-agent" {
+			t.Errorf("user agent = %q", got)
+		}
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["client_id"] != "key" || body["client_secret"] != "secret" || body["code"] != "abc" {
+			t.Errorf("unexpected body %v", body)
+		}
+		_, _ = w.Write([]byte(`{"access_token":"tok","scope":"read_products"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv, WithUserAgent("test-agent"))
+	resp, err := c.ExchangeCodeForToken(context.Background(), "test-store.myshopify.com", "abc")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if resp.AccessToken != "tok" || resp.Scope != "read_products" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestExchangeCodeForApp_UsesAppCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["client_id"] != "partner-key" || body["client_secret"] != "partner-secret" {
+			t.Errorf("unexpected credentials %v", body)
+		}
+		_, _ = w.Write([]byte(`{"access_token":"tok","scope":"read_products"}`))
+	}))
+	defer srv.Close()
+
+	app := Credentials{APIKey: "partner-key", APISecret: "partner-secret"}
+	if _, err := newTestClient(srv).ExchangeCodeForApp(context.Background(), app, "test-store.myshopify.com", "abc"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestClient_RetriesTransient5xx(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_scopes":[{"handle":"read_products"}]}`))
+	}))
+	defer srv.Close()
+
+	scopes, err := newTestClient(srv).FetchAccessScopes(context.Background(), "test-store.myshopify.com", "tok")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(scopes) != 1 || scopes[0] != "read_products" {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	if _, err := newTestClient(srv).FetchAccessScopes(context.Background(), "test-store.myshopify.com", "tok"); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+// TestClient_DoesNotReplayTokenExchange: the authorization code is single-use, once Shopify may have
+// redeemed it a retry could only fail with "invalid code"
+func TestClient_DoesNotReplayTokenExchange(t *testing.T) {
+	for name, fail := range map[string]http.HandlerFunc{
+		"5xx": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) },
+		"lost response": func(w http.ResponseWriter, r *http.Request) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				fail(w, r)
+			}))
+			defer srv.Close()
+
+			if _, err := newTestClient(srv).ExchangeCodeForToken(context.Background(), "test-store.myshopify.com", "abc"); err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if calls.Load() != 1 {
+				t.Fatalf("token exchange sent %d times", calls.Load())
+			}
+		})
+	}
+
+	// a request that never left the client is safe to repeat
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	attempts := 0
+	c := newTestClient(srv, WithRequestObserver(func(string, int, time.Duration) { attempts++ }))
+	if _, err := c.ExchangeCodeForToken(context.Background(), "test-store.myshopify.com", "abc"); err == nil {
+		t.Fatalf("expected dial error, got nil")
+	}
+	if attempts != 3 {
+		t.Fatalf("expected dial errors to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestClient_DoesNotRetry4xx(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := newTestClient(srv).FetchAccessScopes(context.Background(), "test-store.myshopify.com", "tok")
+	if err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestClient_ContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := newTestClient(srv).ExchangeCodeForToken(ctx, "test-store.myshopify.com", "abc"); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("request was not cancelled with the context")
+	}
+}
+
+func TestClient_LogsWithContextLogger(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_scopes":[]}`))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	reqLog := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With("request_id", "req-42")
+	ctx := logctx.With(context.Background(), reqLog)
+
+	if _, err := newTestClient(srv).FetchAccessScopes(ctx, "test-store.myshopify.com", "shpat_secret"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "retrying shopify request") || strings.Count(out, "request_id=req-42") != 3 {
+		t.Fatalf("expected two attempts and a retry logged with the request id, got:\n%s", out)
+	}
+	if strings.Contains(out, "shpat_secret") {
+		t.Fatalf("access token leaked into logs:\n%s", out)
+	}
+}
+
+func TestClient_ObservesEveryAttempt(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_scopes":[]}`))
+	}))
+	defer srv.Close()
+
+	type attempt struct {
+		endpoint string
+		status   int
+	}
+	var seen []attempt
+	c := newTestClient(srv, WithRequestObserver(func(endpoint string, status int, d time.Duration) {
+		seen = append(seen, attempt{endpoint, status})
+	}))
+	if _, err := c.FetchAccessScopes(context.Background(), "test-store.myshopify.com", "tok"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	want := []attempt{{"/admin/oauth/access_scopes.json", 502}, {"/admin/oauth/access_scopes.json", 200}}
+	if len(seen) != len(want) || seen[0] != want[0] || seen[1] != want[1] {
+		t.Fatalf("observed %v, want %v", seen, want)
+	}
+	if got := endpoint("/admin/api/2025-01/graphql.json"); got != "/admin/api/{version}/graphql.json" {
+		t.Fatalf("endpoint = %q", got)
+	}
+}
+
+func TestClient_TracesAndPropagates(t *testing.T) {
+	rec := telemetrytest.RecordSpans()
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		_, _ = w.Write([]byte(`{"access_scopes":[]}`))
+	}))
+	defer srv.Close()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	if _, err := newTestClient(srv).FetchAccessScopes(ctx, "test-store.myshopify.com", "tok"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	parent.End()
+
+	traceID := parent.SpanContext().TraceID()
+	if !strings.Contains(traceparent, traceID.String()) {
+		t.Fatalf("traceparent %q does not carry trace %s", traceparent, traceID)
+	}
+
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID() != traceID || s.Name() != "GET /admin/oauth/access_scopes.json" {
+			continue
+		}
+		if s.SpanKind() != trace.SpanKindClient {
+			t.Fatalf("span kind = %v", s.SpanKind())
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range s.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		if attrs[telemetry.ShopDomainKey].AsString() != "test-store.myshopify.com" || attrs["http.response.status_code"].AsInt64() != 200 {
+			t.Fatalf("unexpected attributes %v", attrs)
+		}
+		return
+	}
+	t.Fatalf("no client span recorded for the Shopify call")
+}
+
+func TestFetchShop_GraphQLErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/api/"+apiVersion+"/graphql.json" || r.Header.Get("X-Shopify-Access-Token") != "tok" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		_, _ = w.Write([]byte(`{"errors":[{"message":"Access denied for shop field."}]}`))
+	}))
+	defer srv.Close()
+
+	if _, err := newTestClient(srv).FetchShop(context.Background(), "test-store.myshopify.com", "tok"); err == nil {
+		t.Fatal("expected an error for a GraphQL error response")
+	}
+}
@@ -1,17 +1,22 @@
 package shopify
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/url"
 )
 
 func ValidateHMAC(queryParams url.Values, secret string) error {
+	_, err := VerifyHMAC(queryParams, Secrets{Primary: secret})
+	return err
+}
+
+// VerifyHMAC checks the hmac query parameter of a Shopify-signed request against both secrets
+// and returns which one matched
+func VerifyHMAC(queryParams url.Values, secrets Secrets) (string, error) {
 	receivedHMAC := queryParams.Get("hmac")
 	if receivedHMAC == "" {
-		return fmt.Errorf("missing hmac parameter")
+		return "", fmt.Errorf("missing hmac parameter")
 	}
 
 	paramsToSign := url.Values{}
@@ -26,18 +31,15 @@ func ValidateHMAC(queryParams url.Values, secret string) error {
 
 	message := paramsToSign.Encode()
 
-	mac := hmac.New(sha256.New, []byte(secret))
-	_, _ = mac.Write([]byte(message))
-	calculated := mac.Sum(nil)
-
 	receivedBytes, err := hex.DecodeString(receivedHMAC)
 	if err != nil {
-		return fmt.Errorf("invalid hmac: not valid hex")
+		return "", fmt.Errorf("invalid hmac: not valid hex")
 	}
 
-	if !hmac.Equal(calculated, receivedBytes) {
-		return fmt.Errorf("hmac validation failed: signature mismatch")
+	secret, ok := secrets.match([]byte(message), receivedBytes)
+	if !ok {
+		return "", fmt.Errorf("hmac validation failed: signature mismatch")
 	}
 
-	return nil
+	return secret, nil
 }
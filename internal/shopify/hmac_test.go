@@ -67,3 +67,29 @@ func TestValidateHMAC_Tampered(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+func TestVerifyHMAC_EitherSecret(t *testing.T) {
+	secrets := Secrets{Primary: "new_secret", Secondary: "old_secret"}
+
+	v := url.Values{}
+	v.Set("shop", "test-store.myshopify.com")
+	v.Set("timestamp", "1700000000")
+
+	for secret, want := range map[string]string{"new_secret": SecretPrimary, "old_secret": SecretSecondary} {
+		v.Set("hmac", signForTest(v, secret))
+		got, err := VerifyHMAC(v, secrets)
+		if err != nil || got != want {
+			t.Fatalf("signed with %s: got %q, %v, want %q", secret, got, err, want)
+		}
+	}
+
+	v.Set("hmac", signForTest(v, "other_secret"))
+	if _, err := VerifyHMAC(v, secrets); err == nil {
+		t.Fatalf("expected error for an unknown secret")
+	}
+	// without a secondary secret only the primary one is accepted
+	v.Set("hmac", signForTest(v, "old_secret"))
+	if _, err := VerifyHMAC(v, Secrets{Primary: "new_secret"}); err == nil {
+		t.Fatalf("expected error once the secondary secret is dropped")
+	}
+}
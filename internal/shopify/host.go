@@ -0,0 +1,60 @@
+package shopify
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// adminHosts are the Shopify admin hosts a decoded `host` parameter may point at,
+// besides the shop's own legacy <shop>/admin
+var adminHosts = []string{"admin.shopify.com"}
+
+// ParseHost decodes the base64 `host` launch parameter (e.g. admin.shopify.com/store/<handle>)
+// and checks that it is a Shopify admin belonging to shop. It returns the decoded host
+func ParseHost(raw, shopDomain string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing host parameter")
+	}
+
+	decoded, err := decodeBase64(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid host: not valid base64")
+	}
+	host := string(decoded)
+	if strings.ContainsAny(host, " \t\r\n?#@\\") || strings.Contains(host, "://") {
+		return "", fmt.Errorf("invalid host: unexpected characters")
+	}
+
+	hostname, path, _ := strings.Cut(host, "/")
+	hostname = strings.ToLower(hostname)
+	path = "/" + strings.TrimSuffix(path, "/")
+
+	handle, ok := strings.CutSuffix(shopDomain, ".myshopify.com")
+	if !ok || handle == "" {
+		return "", fmt.Errorf("invalid host: shop is not a myshopify.com domain")
+	}
+
+	for _, allowed := range adminHosts {
+		if hostname == allowed {
+			if path != "/store/"+handle {
+				return "", fmt.Errorf("invalid host: does not belong to shop")
+			}
+			return allowed + path, nil
+		}
+	}
+
+	// legacy admin served from the shop domain itself
+	if hostname == shopDomain && path == "/admin" {
+		return shopDomain + "/admin", nil
+	}
+	return "", fmt.Errorf("invalid host: not a shopify admin host")
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
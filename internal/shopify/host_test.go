@@ -0,0 +1,42 @@
+package shopify
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	shop := "test-store.myshopify.com"
+	enc := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	ok := map[string]string{
+		enc("admin.shopify.com/store/test-store"):                                          "admin.shopify.com/store/test-store",
+		base64.RawURLEncoding.EncodeToString([]byte("admin.shopify.com/store/test-store")): "admin.shopify.com/store/test-store",
+		enc("test-store.myshopify.com/admin"):                                              "test-store.myshopify.com/admin",
+	}
+	for raw, want := range ok {
+		got, err := ParseHost(raw, shop)
+		if err != nil {
+			t.Fatalf("ParseHost(%q) unexpected err: %v", raw, err)
+		}
+		if got != want {
+			t.Fatalf("ParseHost(%q) = %q, want %q", raw, got, want)
+		}
+	}
+
+	bad := []string{
+		"",
+		"!!!not-base64",
+		enc("admin.shopify.com/store/other-store"),
+		enc("evil.com/store/test-store"),
+		enc("https://admin.shopify.com/store/test-store"),
+		enc("admin.shopify.com/store/test-store?x=1"),
+		enc("other-store.myshopify.com/admin"),
+		enc("admin.shopify.com.evil.com/store/test-store"),
+	}
+	for _, raw := range bad {
+		if _, err := ParseHost(raw, shop); err == nil {
+			t.Fatalf("ParseHost(%q) expected error", raw)
+		}
+	}
+}
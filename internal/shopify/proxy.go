@@ -0,0 +1,59 @@
+package shopify
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifyProxySignature checks the signature parameter Shopify adds to app proxy requests against both
+// secrets and returns which one matched. Unlike the hmac of admin requests, the message is every other
+// parameter as key=value, sorted and concatenated without separators, repeated values joined with commas.
+// The signed timestamp must be within signatureLeeway of now, so a captured proxy URL cannot be replayed
+func VerifyProxySignature(queryParams url.Values, secrets Secrets, now time.Time) (string, error) {
+	received := queryParams.Get("signature")
+	if received == "" {
+		return "", fmt.Errorf("missing signature parameter")
+	}
+
+	receivedBytes, err := hex.DecodeString(received)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: not valid hex")
+	}
+
+	secret, ok := secrets.match(proxyMessage(queryParams), receivedBytes)
+	if !ok {
+		return "", fmt.Errorf("proxy signature validation failed: signature mismatch")
+	}
+
+	ts, err := strconv.ParseInt(queryParams.Get("timestamp"), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid proxy timestamp")
+	}
+	if skew := now.Sub(time.Unix(ts, 0)).Abs(); skew > signatureLeeway {
+		return "", fmt.Errorf("proxy request timestamp is %s off", skew.Round(time.Second))
+	}
+
+	return secret, nil
+}
+
+// proxyMessage is what Shopify signs for an app proxy request
+func proxyMessage(queryParams url.Values) []byte {
+	keys := make([]string, 0, len(queryParams))
+	for key := range queryParams {
+		if key != "signature" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var message strings.Builder
+	for _, key := range keys {
+		message.WriteString(key + "=" + strings.Join(queryParams[key], ","))
+	}
+	return []byte(message.String())
+}
@@ -0,0 +1,68 @@
+package shopify
+
+import (
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyProxySignature(t *testing.T) {
+	// example from the Shopify app proxy documentation, signed with the secret "hush"
+	q, err := url.ParseQuery("extra=1&extra=2&shop=shop-name.myshopify.com&path_prefix=%2Fapps%2Fawesome_reviews" +
+		"&timestamp=1317327555&signature=a9718877bea71c2484f91608a7eaea1532bdf71f5c56825065fa4ccabe549ef3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedAt := time.Unix(1317327555, 0)
+
+	if got, err := VerifyProxySignature(q, Secrets{Primary: "hush"}, signedAt); err != nil || got != SecretPrimary {
+		t.Fatalf("got %q, %v, want primary", got, err)
+	}
+	if got, err := VerifyProxySignature(q, Secrets{Primary: "rotated", Secondary: "hush"}, signedAt); err != nil || got != SecretSecondary {
+		t.Fatalf("got %q, %v, want secondary", got, err)
+	}
+
+	q.Set("shop", "evil.myshopify.com")
+	if _, err := VerifyProxySignature(q, Secrets{Primary: "hush"}, signedAt); err == nil {
+		t.Fatalf("expected error for a tampered query")
+	}
+	q.Del("signature")
+	if _, err := VerifyProxySignature(q, Secrets{Primary: "hush"}, signedAt); err == nil {
+		t.Fatalf("expected error for a missing signature")
+	}
+}
+
+func TestVerifyProxySignature_Timestamp(t *testing.T) {
+	now := time.Unix(1735732800, 0)
+	sign := func(ts string) url.Values {
+		q := url.Values{}
+		q.Set("shop", "shop-name.myshopify.com")
+		q.Set("path_prefix", "/apps/reviews")
+		if ts != "" {
+			q.Set("timestamp", ts)
+		}
+		q.Set("signature", hex.EncodeToString(sign("hush", proxyMessage(q))))
+		return q
+	}
+	at := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+
+	for name, tc := range map[string]struct {
+		ts string
+		ok bool
+	}{
+		"now":                {at(0), true},
+		"within leeway":      {at(-signatureLeeway), true},
+		"clock ahead":        {at(signatureLeeway), true},
+		"replayed later":     {at(-signatureLeeway - time.Second), false},
+		"from the future":    {at(signatureLeeway + time.Second), false},
+		"captured yesterday": {at(-24 * time.Hour), false},
+		"missing":            {"", false},
+		"not a number":       {"yesterday", false},
+	} {
+		if _, err := VerifyProxySignature(sign(tc.ts), Secrets{Primary: "hush"}, now); (err == nil) != tc.ok {
+			t.Errorf("%s: got err %v, want ok=%v", name, err, tc.ok)
+		}
+	}
+}
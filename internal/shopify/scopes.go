@@ -0,0 +1,48 @@
+package shopify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrUnauthorized is returned when Shopify rejects the access token (revoked or app uninstalled)
+var ErrUnauthorized = errors.New("shopify rejected access token")
+
+type accessScopesResponse struct {
+	AccessScopes []struct {
+		Handle string `json:"handle"`
+	} `json:"access_scopes"`
+}
+
+// FetchAccessScopes returns the scopes currently granted to the app for the given shop
+func (c *Client) FetchAccessScopes(ctx context.Context, shopDomain, accessToken string) ([]string, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("X-Shopify-Access-Token", accessToken)
+
+	status, body, err := c.do(ctx, retryIdempotent, shopDomain, http.MethodGet, "/admin/oauth/access_scopes.json", header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return nil, ErrUnauthorized
+	}
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
+	}
+
+	var scopesResp accessScopesResponse
+	if err := json.Unmarshal(body, &scopesResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	scopes := make([]string, 0, len(scopesResp.AccessScopes))
+	for _, s := range scopesResp.AccessScopes {
+		scopes = append(scopes, s.Handle)
+	}
+	return scopes, nil
+}
@@ -0,0 +1,36 @@
+package shopify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// labels reported by the verifiers for the secret a signature matched
+const (
+	SecretPrimary   = "primary"
+	SecretSecondary = "secondary"
+)
+
+// Secrets is an app's client secret plus, while Shopify rotates it, the previous one. Signed requests
+// may use either during the rotation window, so every verifier accepts both
+type Secrets struct {
+	Primary   string
+	Secondary string
+}
+
+// match returns the label of the secret whose HMAC-SHA256 of msg equals sig
+func (s Secrets) match(msg, sig []byte) (string, bool) {
+	if hmac.Equal(sign(s.Primary, msg), sig) {
+		return SecretPrimary, true
+	}
+	if s.Secondary != "" && hmac.Equal(sign(s.Secondary, msg), sig) {
+		return SecretSecondary, true
+	}
+	return "", false
+}
+
+func sign(secret string, msg []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(msg)
+	return mac.Sum(nil)
+}
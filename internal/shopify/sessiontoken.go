@@ -0,0 +1,96 @@
+package shopify
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// signatureLeeway absorbs clock skew between Shopify and us on session token exp and nbf and on the
+// timestamp of app proxy requests
+const signatureLeeway = 10 * time.Second
+
+// SessionToken holds the claims of an App Bridge session token, the HS256 JWT an embedded app
+// frontend sends as "Authorization: Bearer <token>"
+type SessionToken struct {
+	Issuer    string `json:"iss"`
+	Dest      string `json:"dest"`
+	Audience  string `json:"aud"`
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	IssuedAt  int64  `json:"iat"`
+	ID        string `json:"jti"`
+	SessionID string `json:"sid"`
+
+	shop string
+}
+
+// Shop is the shop domain the token was issued for, taken from dest
+func (t SessionToken) Shop() string {
+	return t.shop
+}
+
+// VerifySessionToken checks the signature against both secrets, that the token was issued for apiKey
+// and that it is within its validity window. It returns the claims and which secret matched
+func VerifySessionToken(token, apiKey string, secrets Secrets, now time.Time) (SessionToken, string, error) {
+	var claims SessionToken
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, "", fmt.Errorf("invalid session token: not a jwt")
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, "", fmt.Errorf("invalid session token: header not valid base64")
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return claims, "", fmt.Errorf("invalid session token: unsupported alg")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, "", fmt.Errorf("invalid session token: signature not valid base64")
+	}
+	secret, ok := secrets.match([]byte(parts[0]+"."+parts[1]), sig)
+	if !ok {
+		return claims, "", fmt.Errorf("session token validation failed: signature mismatch")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, "", fmt.Errorf("invalid session token: payload not valid base64")
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, "", fmt.Errorf("invalid session token: %w", err)
+	}
+
+	if claims.Audience != apiKey {
+		return claims, "", fmt.Errorf("invalid session token: issued for another app")
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(signatureLeeway)) {
+		return claims, "", fmt.Errorf("invalid session token: expired")
+	}
+	if now.Add(signatureLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return claims, "", fmt.Errorf("invalid session token: not valid yet")
+	}
+
+	dest, err := url.Parse(claims.Dest)
+	if err != nil || dest.Scheme != "https" || dest.Host == "" {
+		return claims, "", fmt.Errorf("invalid session token: invalid dest")
+	}
+	iss, err := url.Parse(claims.Issuer)
+	if err != nil || !strings.EqualFold(iss.Host, dest.Host) {
+		return claims, "", fmt.Errorf("invalid session token: iss does not match dest")
+	}
+	claims.shop = strings.ToLower(dest.Host)
+
+	return claims, secret, nil
+}
@@ -0,0 +1,77 @@
+package shopify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sessionTokenForTest(t *testing.T, claims map[string]any, secret string) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySessionToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secrets := Secrets{Primary: "new_secret", Secondary: "old_secret"}
+	claims := func() map[string]any {
+		return map[string]any{
+			"iss":  "https://Test-Store.myshopify.com/admin",
+			"dest": "https://Test-Store.myshopify.com",
+			"aud":  "api-key",
+			"sub":  "42",
+			"exp":  now.Add(time.Minute).Unix(),
+			"nbf":  now.Unix(),
+			"iat":  now.Unix(),
+		}
+	}
+
+	tok, secret, err := VerifySessionToken(sessionTokenForTest(t, claims(), "old_secret"), "api-key", secrets, now)
+	if err != nil || secret != SecretSecondary {
+		t.Fatalf("got %q, %v, want secondary", secret, err)
+	}
+	if tok.Shop() != "test-store.myshopify.com" || tok.Subject != "42" {
+		t.Fatalf("unexpected claims %+v, shop %q", tok, tok.Shop())
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(map[string]any)
+		secret string
+	}{
+		{"unknown secret", func(map[string]any) {}, "other_secret"},
+		{"other app", func(c map[string]any) { c["aud"] = "other-key" }, "new_secret"},
+		{"expired", func(c map[string]any) { c["exp"] = now.Add(-time.Minute).Unix() }, "new_secret"},
+		{"not valid yet", func(c map[string]any) { c["nbf"] = now.Add(time.Minute).Unix() }, "new_secret"},
+		{"iss from another shop", func(c map[string]any) { c["iss"] = "https://evil.myshopify.com/admin" }, "new_secret"},
+		{"plain http dest", func(c map[string]any) { c["dest"] = "http://test-store.myshopify.com" }, "new_secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := claims()
+			tt.mutate(c)
+			if _, _, err := VerifySessionToken(sessionTokenForTest(t, c, tt.secret), "api-key", secrets, now); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+
+	// alg none must never be accepted, whatever the signature
+	good := sessionTokenForTest(t, claims(), "new_secret")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + good[strings.Index(good, "."):]
+	if _, _, err := VerifySessionToken(none, "api-key", secrets, now); err == nil {
+		t.Fatalf("expected error for alg none")
+	}
+}
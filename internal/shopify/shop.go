@@ -0,0 +1,98 @@
+package shopify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ShopMetadata is what support needs to know about a shop besides its domain
+type ShopMetadata struct {
+	Name          string
+	Email         string
+	ContactEmail  string
+	PlanName      string
+	CurrencyCode  string
+	IanaTimezone  string
+	PrimaryDomain string
+}
+
+const shopQuery = `query {
+  shop {
+    name
+    email
+    contactEmail
+    currencyCode
+    ianaTimezone
+    plan { displayName }
+    primaryDomain { host }
+  }
+}`
+
+type shopQueryResponse struct {
+	Data struct {
+		Shop *struct {
+			Name         string `json:"name"`
+			Email        string `json:"email"`
+			ContactEmail string `json:"contactEmail"`
+			CurrencyCode string `json:"currencyCode"`
+			IanaTimezone string `json:"ianaTimezone"`
+			Plan         struct {
+				DisplayName string `json:"displayName"`
+			} `json:"plan"`
+			PrimaryDomain struct {
+				Host string `json:"host"`
+			} `json:"primaryDomain"`
+		} `json:"shop"`
+	} `json:"data"`
+	Errors json.RawMessage `json:"errors"`
+}
+
+// FetchShop reads the shop's metadata through the GraphQL Admin API
+func (c *Client) FetchShop(ctx context.Context, shopDomain, accessToken string) (*ShopMetadata, error) {
+	reqBody, err := json.Marshal(map[string]string{"query": shopQuery})
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+	header.Set("X-Shopify-Access-Token", accessToken)
+
+	status, body, err := c.do(ctx, retryIdempotent, shopDomain, http.MethodPost, "/admin/api/"+apiVersion+"/graphql.json", header, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return nil, ErrUnauthorized
+	}
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
+	}
+
+	var resp shopQueryResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	// GraphQL reports query errors with a 200; the messages are kept out of the error, they may echo the query
+	if len(resp.Errors) > 0 && string(resp.Errors) != "null" {
+		return nil, fmt.Errorf("shop query returned errors")
+	}
+	if resp.Data.Shop == nil {
+		return nil, fmt.Errorf("shop query returned no shop")
+	}
+
+	s := resp.Data.Shop
+	return &ShopMetadata{
+		Name:          s.Name,
+		Email:         s.Email,
+		ContactEmail:  s.ContactEmail,
+		PlanName:      s.Plan.DisplayName,
+		CurrencyCode:  s.CurrencyCode,
+		IanaTimezone:  s.IanaTimezone,
+		PrimaryDomain: strings.ToLower(s.PrimaryDomain.Host),
+	}, nil
+}
@@ -0,0 +1,472 @@
+// Package shopifytest provides an in-process fake Shopify for tests.
+// It serves the OAuth endpoints, access scopes, a small GraphQL surface and
+// signs webhook deliveries, with hooks to inject failures.
+package shopifytest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"shopify-auth-app/internal/shopify"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAPIKey    = "test-api-key"
+	DefaultAPISecret = "test-api-secret"
+	DefaultShop      = "test-store.myshopify.com"
+)
+
+// ShopInfo is what the GraphQL `shop` query returns
+type ShopInfo struct {
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	ContactEmail  string `json:"contactEmail"`
+	CurrencyCode  string `json:"currencyCode"`
+	IanaTimezone  string `json:"ianaTimezone"`
+	PlanName      string `json:"-"`
+	PrimaryDomain string `json:"-"`
+}
+
+type grant struct {
+	shop   string
+	scopes string
+}
+
+// Server is a fake Shopify backed by httptest.Server
+type Server struct {
+	*httptest.Server
+
+	APIKey    string
+	APISecret string
+
+	mu       sync.Mutex
+	codes    map[string]grant // authorization code -> grant, single use
+	tokens   map[string]grant // access token -> grant
+	failures map[string][]int // path -> queued status codes
+	delay    time.Duration
+
+	rejectCodes bool
+	downgrade   *string
+	shopInfo    ShopInfo
+}
+
+// NewServer starts a fake Shopify using the default credentials. Close it when done
+func NewServer() *Server {
+	return NewServerWithCredentials(DefaultAPIKey, DefaultAPISecret)
+}
+
+func NewServerWithCredentials(apiKey, apiSecret string) *Server {
+	s := &Server{
+		APIKey:    apiKey,
+		APISecret: apiSecret,
+		codes:     map[string]grant{},
+		tokens:    map[string]grant{},
+		failures:  map[string][]int{},
+		shopInfo: ShopInfo{
+			Name:          "Test Store",
+			Email:         "owner@example.com",
+			ContactEmail:  "support@example.com",
+			CurrencyCode:  "USD",
+			IanaTimezone:  "America/New_York",
+			PlanName:      "Basic",
+			PrimaryDomain: "test-store.example.com",
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/oauth/authorize", s.handleAuthorize)
+	mux.HandleFunc("POST /admin/oauth/access_token", s.handleAccessToken)
+	mux.HandleFunc("GET /admin/oauth/access_scopes.json", s.handleAccessScopes)
+	mux.HandleFunc("POST /admin/api/{version}/graphql.json", s.handleGraphQL)
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// Client returns a shopify.Client pointed at this server
+func (s *Server) Client(opts ...shopify.ClientOption) *shopify.Client {
+	opts = append([]shopify.ClientOption{
+		shopify.WithBaseURL(s.URL),
+		shopify.WithRetries(0, 0, 0),
+	}, opts...)
+	return shopify.NewClient(s.APIKey, s.APISecret, opts...)
+}
+
+// FailNext makes the next `times` requests to path answer with status
+func (s *Server) FailNext(path string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < times; i++ {
+		s.failures[path] = append(s.failures[path], status)
+	}
+}
+
+// SetDelay delays every response, used to exercise timeouts
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// RejectCodes makes every token exchange fail as if the code was invalid
+func (s *Server) RejectCodes(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectCodes = reject
+}
+
+// DowngradeScopes makes token exchanges and access_scopes.json report scopes
+// instead of what was requested, like a merchant revoking optional scopes
+func (s *Server) DowngradeScopes(scopes string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downgrade = &scopes
+	for tok, g := range s.tokens {
+		g.scopes = scopes
+		s.tokens[tok] = g
+	}
+}
+
+func (s *Server) SetShopInfo(info ShopInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shopInfo = info
+}
+
+// IssueToken registers an access token for shop without going through OAuth
+func (s *Server) IssueToken(shop, scopes string) string {
+	token := "shpat_" + randomHex(16)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = grant{shop: shop, scopes: scopes}
+	return token
+}
+
+// Approve plays the merchant approving the install screen for an authorize URL built by
+// shopify.BuildAuthorizeURL and returns the signed callback URL Shopify would redirect to
+func (s *Server) Approve(authorizeURL string) (string, error) {
+	u, err := url.Parse(authorizeURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	shop := q.Get("shop")
+	if shop == "" {
+		shop = u.Hostname()
+	}
+	return s.approve(shop, q)
+}
+
+func (s *Server) approve(shop string, q url.Values) (string, error) {
+	if q.Get("client_id") != s.APIKey {
+		return "", fmt.Errorf("unknown client_id %q", q.Get("client_id"))
+	}
+	redirectURI := q.Get("redirect_uri")
+	if redirectURI == "" || q.Get("state") == "" {
+		return "", fmt.Errorf("missing redirect_uri or state")
+	}
+
+	code := randomHex(16)
+	s.mu.Lock()
+	s.codes[code] = grant{shop: shop, scopes: q.Get("scope")}
+	s.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("shop", shop)
+	params.Set("state", q.Get("state"))
+	params.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	if host := q.Get("host"); host != "" {
+		params.Set("host", host)
+	}
+	params.Set("hmac", s.SignQuery(params))
+
+	cb, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+	cb.RawQuery = params.Encode()
+	return cb.String(), nil
+}
+
+// SignQuery computes the hex hmac Shopify adds to signed query strings
+func (s *Server) SignQuery(v url.Values) string {
+	toSign := url.Values{}
+	for k, vals := range v {
+		if k == "hmac" || k == "signature" {
+			continue
+		}
+		toSign[k] = vals
+	}
+	mac := hmac.New(sha256.New, []byte(s.APISecret))
+	mac.Write([]byte(toSign.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedQuery returns a copy of v with timestamp and hmac set, like an Admin app launch
+func (s *Server) SignedQuery(v url.Values) url.Values {
+	out := url.Values{}
+	for k, vals := range v {
+		out[k] = append([]string(nil), vals...)
+	}
+	if out.Get("timestamp") == "" {
+		out.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	out.Set("hmac", s.SignQuery(out))
+	return out
+}
+
+// SignProxyQuery returns a copy of v with the signature Shopify adds when forwarding an app proxy request.
+// A missing timestamp is set to now
+func (s *Server) SignProxyQuery(v url.Values) url.Values {
+	out := url.Values{}
+	for k, vals := range v {
+		if k != "signature" {
+			out[k] = append([]string(nil), vals...)
+		}
+	}
+	if out.Get("timestamp") == "" {
+		out.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	keys := make([]string, 0, len(out))
+	for k := range out {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var msg strings.Builder
+	for _, k := range keys {
+		msg.WriteString(k + "=" + strings.Join(out[k], ","))
+	}
+	mac := hmac.New(sha256.New, []byte(s.APISecret))
+	mac.Write([]byte(msg.String()))
+	out.Set("signature", hex.EncodeToString(mac.Sum(nil)))
+	return out
+}
+
+// SessionToken issues an App Bridge session token for shop at now, valid for a minute like the real ones
+func (s *Server) SessionToken(shop string, now time.Time) string {
+	claims, _ := json.Marshal(map[string]any{
+		"iss":  "https://" + shop + "/admin",
+		"dest": "https://" + shop,
+		"aud":  s.APIKey,
+		"sub":  "42",
+		"exp":  now.Add(time.Minute).Unix(),
+		"nbf":  now.Unix(),
+		"iat":  now.Unix(),
+		"jti":  randomHex(8),
+		"sid":  randomHex(16),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, []byte(s.APISecret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignWebhook computes the X-Shopify-Hmac-Sha256 header for body
+func (s *Server) SignWebhook(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.APISecret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookRequest builds a signed webhook delivery for topic without sending it
+func (s *Server) NewWebhookRequest(targetURL, topic, shop string, payload any) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shopify-Topic", topic)
+	req.Header.Set("X-Shopify-Shop-Domain", shop)
+	req.Header.Set("X-Shopify-Hmac-Sha256", s.SignWebhook(body))
+	req.Header.Set("X-Shopify-Webhook-Id", randomHex(8))
+	req.Header.Set("X-Shopify-API-Version", "2025-10")
+	return req, nil
+}
+
+// DeliverWebhook sends a signed webhook to targetURL
+func (s *Server) DeliverWebhook(targetURL, topic, shop string, payload any) (*http.Response, error) {
+	req, err := s.NewWebhookRequest(targetURL, topic, shop, payload)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		delay := s.delay
+		var status int
+		if queued := s.failures[r.URL.Path]; len(queued) > 0 {
+			status, s.failures[r.URL.Path] = queued[0], queued[1:]
+		}
+		s.mu.Unlock()
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if status != 0 {
+			writeJSON(w, status, map[string]string{"errors": http.StatusText(status)})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	shop := q.Get("shop")
+	if shop == "" {
+		shop = DefaultShop
+	}
+	cb, err := s.approve(shop, q)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errors": err.Error()})
+		return
+	}
+	http.Redirect(w, r, cb, http.StatusFound)
+}
+
+func (s *Server) handleAccessToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		Code         string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if req.ClientID != s.APIKey || req.ClientSecret != s.APISecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[req.Code]
+	delete(s.codes, req.Code)
+	if s.rejectCodes {
+		ok = false
+	}
+	if ok && s.downgrade != nil {
+		g.scopes = *s.downgrade
+	}
+	var token string
+	if ok {
+		token = "shpat_" + randomHex(16)
+		s.tokens[token] = g
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_request",
+			"error_description": "The authorization code was not found or was already used",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, shopify.AccessTokenResponse{AccessToken: token, Scope: g.scopes})
+}
+
+func (s *Server) lookupToken(r *http.Request) (grant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.tokens[r.Header.Get("X-Shopify-Access-Token")]
+	return g, ok
+}
+
+func (s *Server) handleAccessScopes(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.lookupToken(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"errors": "[API] Invalid API key or access token"})
+		return
+	}
+
+	type handle struct {
+		Handle string `json:"handle"`
+	}
+	out := struct {
+		AccessScopes []handle `json:"access_scopes"`
+	}{AccessScopes: []handle{}}
+	for _, sc := range strings.Split(g.scopes, ",") {
+		if sc = strings.TrimSpace(sc); sc != "" {
+			out.AccessScopes = append(out.AccessScopes, handle{Handle: sc})
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.lookupToken(r); !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"errors": "[API] Invalid API key or access token"})
+		return
+	}
+
+	var req struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errors": "invalid json"})
+		return
+	}
+
+	if !strings.Contains(req.Query, "shop") {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"errors": []map[string]string{{"message": "shopifytest: unsupported query"}},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	info := s.shopInfo
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": map[string]any{
+			"shop": map[string]any{
+				"name":          info.Name,
+				"email":         info.Email,
+				"contactEmail":  info.ContactEmail,
+				"currencyCode":  info.CurrencyCode,
+				"ianaTimezone":  info.IanaTimezone,
+				"plan":          map[string]string{"displayName": info.PlanName},
+				"primaryDomain": map[string]string{"host": info.PrimaryDomain},
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,142 @@
+package shopifytest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"shopify-auth-app/internal/shopify"
+	"testing"
+	"time"
+)
+
+func authorize(t *testing.T, s *Server, scopes string) url.Values {
+	t.Helper()
+
+	authURL, err := shopify.BuildAuthorizeURL(DefaultShop, s.APIKey, scopes, "https://app.example.com/auth/callback", "nonce")
+	if err != nil {
+		t.Fatalf("build authorize url: %v", err)
+	}
+	cb, err := s.Approve(authURL)
+	if err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	u, err := url.Parse(cb)
+	if err != nil {
+		t.Fatalf("parse callback: %v", err)
+	}
+	return u.Query()
+}
+
+func TestServer_OAuthRoundTrip(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ctx := context.Background()
+
+	q := authorize(t, s, "read_products,write_orders")
+	if q.Get("state") != "nonce" || q.Get("shop") != DefaultShop {
+		t.Fatalf("unexpected callback params %v", q)
+	}
+	if err := shopify.ValidateHMAC(q, s.APISecret); err != nil {
+		t.Fatalf("callback hmac: %v", err)
+	}
+
+	client := s.Client()
+	tok, err := client.ExchangeCodeForToken(ctx, DefaultShop, q.Get("code"))
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if tok.Scope != "read_products,write_orders" {
+		t.Fatalf("unexpected scope %q", tok.Scope)
+	}
+
+	// codes are single use
+	if _, err := client.ExchangeCodeForToken(ctx, DefaultShop, q.Get("code")); err == nil {
+		t.Fatalf("expected reused code to fail")
+	}
+
+	scopes, err := client.FetchAccessScopes(ctx, DefaultShop, tok.AccessToken)
+	if err != nil {
+		t.Fatalf("access scopes: %v", err)
+	}
+	if len(scopes) != 2 {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+}
+
+func TestServer_FailureInjection(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ctx := context.Background()
+
+	s.RejectCodes(true)
+	q := authorize(t, s, "read_products")
+	if _, err := s.Client().ExchangeCodeForToken(ctx, DefaultShop, q.Get("code")); err == nil {
+		t.Fatalf("expected rejected code to fail")
+	}
+	s.RejectCodes(false)
+
+	s.FailNext("/admin/oauth/access_token", http.StatusServiceUnavailable, 1)
+	q = authorize(t, s, "read_products")
+	if _, err := s.Client().ExchangeCodeForToken(ctx, DefaultShop, q.Get("code")); err == nil {
+		t.Fatalf("expected injected 503 to fail")
+	}
+
+	s.SetDelay(200 * time.Millisecond)
+	q = authorize(t, s, "read_products")
+	if _, err := s.Client(shopify.WithTimeout(20*time.Millisecond)).ExchangeCodeForToken(ctx, DefaultShop, q.Get("code")); err == nil {
+		t.Fatalf("expected slow response to time out")
+	}
+	s.SetDelay(0)
+
+	token := s.IssueToken(DefaultShop, "read_products,write_products")
+	s.DowngradeScopes("read_products")
+	scopes, err := s.Client().FetchAccessScopes(ctx, DefaultShop, token)
+	if err != nil {
+		t.Fatalf("access scopes: %v", err)
+	}
+	if len(scopes) != 1 || scopes[0] != "read_products" {
+		t.Fatalf("expected downgraded scopes, got %v", scopes)
+	}
+}
+
+func TestServer_WebhookSignature(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	req, err := s.NewWebhookRequest("http://localhost/webhooks/app/scopes_update", "app/scopes_update", DefaultShop,
+		map[string]any{"current": []string{"read_products"}})
+	if err != nil {
+		t.Fatalf("new webhook: %v", err)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if err := shopify.ValidateWebhookHMAC(body, req.Header.Get("X-Shopify-Hmac-Sha256"), s.APISecret); err != nil {
+		t.Fatalf("webhook hmac: %v", err)
+	}
+}
+
+func TestServer_ShopQuery(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ctx := context.Background()
+	client := s.Client()
+
+	s.SetShopInfo(ShopInfo{Name: "Renamed", Email: "new@example.com", CurrencyCode: "EUR", PlanName: "Shopify Plus", PrimaryDomain: "Shop.Example.com"})
+	info, err := client.FetchShop(ctx, DefaultShop, s.IssueToken(DefaultShop, "read_products"))
+	if err != nil {
+		t.Fatalf("fetch shop: %v", err)
+	}
+	want := shopify.ShopMetadata{Name: "Renamed", Email: "new@example.com", CurrencyCode: "EUR", PlanName: "Shopify Plus", PrimaryDomain: "shop.example.com"}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+
+	if _, err := client.FetchShop(ctx, DefaultShop, "shpat_unknown"); !errors.Is(err, shopify.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
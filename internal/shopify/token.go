@@ -1,12 +1,10 @@
 package shopify
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"time"
 )
 
 type AccessTokenResponse struct {
@@ -14,13 +12,23 @@ type AccessTokenResponse struct {
 	Scope       string `json:"scope"`
 }
 
-// temporary authorization code for a permanent access token
-func ExchangeCodeForToken(shopDomain, apiKey, apiSecret, code string) (*AccessTokenResponse, error) {
-	tokenURL := fmt.Sprintf("https://%s/admin/oauth/access_token", shopDomain)
+// Credentials identify one Shopify app: the API key (client id) and its secret
+type Credentials struct {
+	APIKey    string
+	APISecret string
+}
+
+// ExchangeCodeForToken trades the temporary authorization code for a permanent access token
+// of the client's own app
+func (c *Client) ExchangeCodeForToken(ctx context.Context, shopDomain, code string) (*AccessTokenResponse, error) {
+	return c.ExchangeCodeForApp(ctx, Credentials{APIKey: c.apiKey, APISecret: c.apiSecret}, shopDomain, code)
+}
 
+// ExchangeCodeForApp is ExchangeCodeForToken for another app, so one client serves every app of a deployment
+func (c *Client) ExchangeCodeForApp(ctx context.Context, app Credentials, shopDomain, code string) (*AccessTokenResponse, error) {
 	requestBody := map[string]string{
-		"client_id":     apiKey,
-		"client_secret": apiSecret,
+		"client_id":     app.APIKey,
+		"client_secret": app.APISecret,
 		"code":          code,
 	}
 
@@ -29,31 +37,18 @@ func ExchangeCodeForToken(shopDomain, apiKey, apiSecret, code string) (*AccessTo
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	req, err := http.NewRequest("POST", tokenURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
 
-	body, err := io.ReadAll(resp.Body)
+	// the code is single-use: a replay after Shopify redeemed it would only report "invalid code"
+	status, body, err := c.do(ctx, retryUnsent, shopDomain, http.MethodPost, "/admin/oauth/access_token", header, jsonBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("shopify returned status %d: %s", resp.StatusCode, string(body))
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
 	}
 
 	var tokenResp AccessTokenResponse
@@ -0,0 +1,31 @@
+package shopify
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// ValidateWebhookHMAC checks the X-Shopify-Hmac-Sha256 header against the raw request body
+func ValidateWebhookHMAC(body []byte, hmacHeader, secret string) error {
+	_, err := VerifyWebhookHMAC(body, hmacHeader, Secrets{Primary: secret})
+	return err
+}
+
+// VerifyWebhookHMAC is ValidateWebhookHMAC accepting both secrets; it returns which one matched
+func VerifyWebhookHMAC(body []byte, hmacHeader string, secrets Secrets) (string, error) {
+	if hmacHeader == "" {
+		return "", fmt.Errorf("missing webhook hmac header")
+	}
+
+	receivedBytes, err := base64.StdEncoding.DecodeString(hmacHeader)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook hmac: not valid base64")
+	}
+
+	secret, ok := secrets.match(body, receivedBytes)
+	if !ok {
+		return "", fmt.Errorf("webhook hmac validation failed: signature mismatch")
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,47 @@
+package shopify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func signWebhookForTest(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateWebhookHMAC_OK(t *testing.T) {
+	secret := "test_secret"
+	body := []byte(`{"id":1,"previous":["read_products"],"current":["read_products","write_products"]}`)
+
+	if err := ValidateWebhookHMAC(body, signWebhookForTest(body, secret), secret); err != nil {
+		t.Fatalf("expected ok, got err: %v", err)
+	}
+}
+
+func TestValidateWebhookHMAC_Tampered(t *testing.T) {
+	secret := "test_secret"
+	body := []byte(`{"id":1,"current":["read_products"]}`)
+	sig := signWebhookForTest(body, secret)
+
+	tampered := []byte(`{"id":1,"current":["write_orders"]}`)
+	if err := ValidateWebhookHMAC(tampered, sig, secret); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if err := ValidateWebhookHMAC(body, "", secret); err == nil {
+		t.Fatalf("expected error for missing header, got nil")
+	}
+}
+
+func TestVerifyWebhookHMAC_SecondarySecret(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	secrets := Secrets{Primary: "new_secret", Secondary: "old_secret"}
+
+	got, err := VerifyWebhookHMAC(body, signWebhookForTest(body, "old_secret"), secrets)
+	if err != nil || got != SecretSecondary {
+		t.Fatalf("got %q, %v, want secondary", got, err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package telemetry configures OpenTelemetry tracing and holds the attributes shared by every span.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// ShopDomainKey is set on every span that acts on behalf of a shop
+const ShopDomainKey = attribute.Key("shopify.shop_domain")
+
+func ShopDomain(shop string) attribute.KeyValue {
+	return ShopDomainKey.String(shop)
+}
+
+type Options struct {
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterOTLP
+	Exporter string
+	// OTLPEndpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318
+	OTLPEndpoint string
+	// SampleRatio is the share of new traces recorded, parent decisions are always honoured
+	SampleRatio float64
+	ServiceName string
+	// Stdout is where the stdout exporter writes, os.Stdout when nil
+	Stdout io.Writer
+}
+
+// Setup installs the global tracer provider and W3C propagators. The returned function flushes
+// pending spans and must be called on shutdown. With ExporterNone only propagation is installed
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := opts.Stdout
+		if w == nil {
+			w = os.Stdout
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("stdout exporter: %w", err)
+		}
+		exporter = exp
+	case ExporterOTLP:
+		var exOpts []otlptracehttp.Option
+		if opts.OTLPEndpoint != "" {
+			exOpts = append(exOpts, otlptracehttp.WithEndpointURL(opts.OTLPEndpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, exOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("otlp exporter: %w", err)
+		}
+		exporter = exp
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", opts.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
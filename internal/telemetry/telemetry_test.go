@@ -0,0 +1,43 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupStdout(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), Options{
+		Exporter:    ExporterStdout,
+		SampleRatio: 1,
+		ServiceName: "auth-test",
+		Stdout:      &buf,
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "install")
+	span.SetAttributes(ShopDomain("test-store.myshopify.com"))
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{`"Name":"install"`, "auth-test", "test-store.myshopify.com"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("exported span missing %q: %s", want, out)
+		}
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Options{Exporter: "zipkin"}); err == nil {
+		t.Fatalf("expected an error for an unknown exporter")
+	}
+}
@@ -0,0 +1,26 @@
+// Package telemetrytest records spans for tests through the global OpenTelemetry providers.
+package telemetrytest
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spansOnce sync.Once
+	spans     = tracetest.NewSpanRecorder()
+)
+
+// RecordSpans installs a recording tracer provider and the W3C propagator once per test binary and
+// returns the shared recorder. Tests tell their spans apart by trace id
+func RecordSpans() *tracetest.SpanRecorder {
+	spansOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spans
+}
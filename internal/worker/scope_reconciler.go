@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"shopify-auth-app/internal/health"
+	"shopify-auth-app/internal/logctx"
+	"shopify-auth-app/internal/repository"
+	"shopify-auth-app/internal/shopify"
+	"slices"
+	"time"
+)
+
+// ShopStore is the subset of repository.ShopRepository the scope reconciler uses
+type ShopStore interface {
+	List(ctx context.Context) ([]repository.Shop, error)
+	Transition(ctx context.Context, apiKey, shopDomain string, to repository.ShopState) (*repository.Shop, error)
+	UpdateScopes(ctx context.Context, apiKey, shopDomain, scopes string) error
+}
+
+// ScopeReconciler periodically compares stored scopes with /admin/oauth/access_scopes.json
+// and corrects drift caused by missed app/scopes_update webhooks
+type ScopeReconciler struct {
+	shopRepo ShopStore
+	shopify  *shopify.Client
+	interval time.Duration
+	log      *slog.Logger
+
+	heartbeat *health.Heartbeat
+}
+
+func NewScopeReconciler(shopRepo ShopStore, client *shopify.Client, interval time.Duration, logger *slog.Logger) *ScopeReconciler {
+	return &ScopeReconciler{
+		shopRepo: shopRepo,
+		shopify:  client,
+		interval: interval,
+		log:      logger,
+		// one missed tick is tolerated, a loop stuck for two intervals is not
+		heartbeat: health.NewHeartbeat(2*interval + time.Minute),
+	}
+}
+
+// Run reconciles once immediately and then on every interval until ctx is cancelled
+func (w *ScopeReconciler) Run(ctx context.Context) {
+	// queries and Shopify calls made by the worker log through its logger
+	ctx = logctx.With(ctx, w.log)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.heartbeat.Beat()
+		if err := w.ReconcileOnce(ctx); err != nil && ctx.Err() == nil {
+			w.log.Error("scope reconciliation failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Heartbeat is beaten on every loop and every shop, readiness fails when it goes stale
+func (w *ScopeReconciler) Heartbeat() *health.Heartbeat {
+	return w.heartbeat
+}
+
+// ReconcileOnce checks every shop with a working token and rewrites scopes that no longer match Shopify.
+// A shop whose token is rejected moves to needs_reauth, so its next login goes through OAuth
+func (w *ScopeReconciler) ReconcileOnce(ctx context.Context) error {
+	ctx = repository.WithActor(ctx, repository.Actor{Name: repository.WorkerActor("scope_reconciler")})
+
+	shops, err := w.shopRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range shops {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// a long pass over many shops is still progress
+		w.heartbeat.Beat()
+		if !s.State.HasToken() {
+			continue
+		}
+
+		granted, err := w.shopify.FetchAccessScopes(ctx, s.ShopDomain, s.OfflineAccessToken)
+		if errors.Is(err, shopify.ErrUnauthorized) {
+			w.log.Warn("access token rejected during scope reconciliation", "api_key", s.APIKey, "shop", s.ShopDomain)
+			if _, err := w.shopRepo.Transition(ctx, s.APIKey, s.ShopDomain, repository.StateNeedsReauth); err != nil {
+				w.log.Error("failed to mark shop for re-authorization", "api_key", s.APIKey, "shop", s.ShopDomain, "err", err)
+			}
+			continue
+		}
+		if err != nil {
+			w.log.Error("failed to fetch access scopes", "api_key", s.APIKey, "shop", s.ShopDomain, "err", err)
+			continue
+		}
+
+		if scopesEqual(s.ScopeList(), granted) {
+			continue
+		}
+
+		if err := w.shopRepo.UpdateScopes(ctx, s.APIKey, s.ShopDomain, repository.JoinScopes(granted)); err != nil {
+			w.log.Error("failed to update drifted scopes", "api_key", s.APIKey, "shop", s.ShopDomain, "err", err)
+			continue
+		}
+		w.log.Info("corrected scope drift", "api_key", s.APIKey, "shop", s.ShopDomain, "stored", s.Scopes, "granted", repository.JoinScopes(granted))
+	}
+	return nil
+}
+
+func scopesEqual(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"shopify-auth-app/internal/repository"
+	"shopify-auth-app/internal/shopify/shopifytest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memShops struct {
+	mu    sync.Mutex
+	shops []repository.Shop
+}
+
+func (m *memShops) List(ctx context.Context) ([]repository.Shop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]repository.Shop(nil), m.shops...), nil
+}
+
+func (m *memShops) Transition(ctx context.Context, apiKey, shopDomain string, to repository.ShopState) (*repository.Shop, error) {
+	s, err := m.update(apiKey, shopDomain, func(s *repository.Shop) { s.State = to })
+	return s, err
+}
+
+func (m *memShops) UpdateScopes(ctx context.Context, apiKey, shopDomain, scopes string) error {
+	_, err := m.update(apiKey, shopDomain, func(s *repository.Shop) { s.Scopes = scopes })
+	return err
+}
+
+func (m *memShops) update(apiKey, shopDomain string, f func(*repository.Shop)) (*repository.Shop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.shops {
+		if m.shops[i].APIKey == apiKey && m.shops[i].ShopDomain == shopDomain {
+			f(&m.shops[i])
+			s := m.shops[i]
+			return &s, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *memShops) get(shopDomain string) repository.Shop {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.shops {
+		if s.ShopDomain == shopDomain {
+			return s
+		}
+	}
+	return repository.Shop{}
+}
+
+func TestScopeReconciler_ReconcileOnce(t *testing.T) {
+	fake := shopifytest.NewServer()
+	defer fake.Close()
+
+	const (
+		drifted  = "drifted-store.myshopify.com"
+		rejected = "rejected-store.myshopify.com"
+		stale    = "gone-store.myshopify.com"
+	)
+	token := fake.IssueToken(drifted, "read_products,write_orders")
+	fake.DowngradeScopes("read_products")
+
+	shops := &memShops{shops: []repository.Shop{
+		{APIKey: "key", ShopDomain: drifted, OfflineAccessToken: token, Scopes: "read_products,write_orders", State: repository.StateActive},
+		{APIKey: "key", ShopDomain: rejected, OfflineAccessToken: "shpat_revoked", Scopes: "read_products", State: repository.StateActive},
+		// no working token, never sent to Shopify
+		{APIKey: "key", ShopDomain: stale, Scopes: "read_products", State: repository.StateUninstalled},
+	}}
+
+	w := NewScopeReconciler(shops, fake.Client(), time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := w.ReconcileOnce(context.Background()); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	if s := shops.get(drifted); s.Scopes != "read_products" || s.State != repository.StateActive {
+		t.Fatalf("drift not corrected: %+v", s)
+	}
+	if s := shops.get(rejected); s.State != repository.StateNeedsReauth || s.Scopes != "read_products" {
+		t.Fatalf("rejected token not marked for re-authorization: %+v", s)
+	}
+	if s := shops.get(stale); s.State != repository.StateUninstalled {
+		t.Fatalf("uninstalled shop touched: %+v", s)
+	}
+}
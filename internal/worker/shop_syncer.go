@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"shopify-auth-app/internal/logctx"
+	"shopify-auth-app/internal/repository"
+	"shopify-auth-app/internal/shopify"
+)
+
+// syncQueueSize bounds the installs waiting for their first metadata sync
+const syncQueueSize = 256
+
+type shopRef struct {
+	apiKey     string
+	shopDomain string
+}
+
+// ShopSyncer copies Shopify's shop object (name, owner email, plan, currency, ...) into the shops row in the
+// background. OAuthCallback enqueues every install, Run backfills installations that were never synced
+type ShopSyncer struct {
+	shopRepo *repository.ShopRepository
+	shopify  *shopify.Client
+	log      *slog.Logger
+
+	queue chan shopRef
+}
+
+func NewShopSyncer(shopRepo *repository.ShopRepository, client *shopify.Client, logger *slog.Logger) *ShopSyncer {
+	return &ShopSyncer{
+		shopRepo: shopRepo,
+		shopify:  client,
+		log:      logger,
+		queue:    make(chan shopRef, syncQueueSize),
+	}
+}
+
+// Enqueue schedules a sync without blocking the request. When the queue is full the shop is dropped
+// and picked up by the backfill of the next start
+func (w *ShopSyncer) Enqueue(apiKey, shopDomain string) {
+	select {
+	case w.queue <- shopRef{apiKey: apiKey, shopDomain: shopDomain}:
+	default:
+		w.log.Warn("shop sync queue full, skipping", "api_key", apiKey, "shop", shopDomain)
+	}
+}
+
+// Run backfills unsynced shops once and then syncs enqueued shops until ctx is cancelled
+func (w *ShopSyncer) Run(ctx context.Context) {
+	// queries and Shopify calls made by the worker log through its logger
+	ctx = logctx.With(ctx, w.log)
+
+	if err := w.backfill(ctx); err != nil && ctx.Err() == nil {
+		w.log.Error("shop metadata backfill failed", "err", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ref := <-w.queue:
+			w.sync(ctx, ref.apiKey, ref.shopDomain)
+		}
+	}
+}
+
+// backfill syncs installations whose metadata was never fetched, e.g. installed before the sync existed
+func (w *ShopSyncer) backfill(ctx context.Context) error {
+	shops, err := w.shopRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range shops {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if s.MetadataSyncedAt == nil && s.State.HasToken() {
+			w.sync(ctx, s.APIKey, s.ShopDomain)
+		}
+	}
+	return nil
+}
+
+// sync logs failures instead of returning them: a shop that failed is retried by the next backfill
+func (w *ShopSyncer) sync(ctx context.Context, apiKey, shopDomain string) {
+	if err := w.SyncOnce(ctx, apiKey, shopDomain); err != nil && ctx.Err() == nil {
+		level := slog.LevelError
+		if errors.Is(err, shopify.ErrUnauthorized) || errors.Is(err, repository.ErrNotFound) {
+			// uninstalled in the meantime
+			level = slog.LevelWarn
+		}
+		w.log.Log(ctx, level, "shop metadata sync failed", "api_key", apiKey, "shop", shopDomain, "err", err)
+	}
+}
+
+// SyncOnce fetches the shop object of one installation and stores it. Shops without a working token are skipped
+func (w *ShopSyncer) SyncOnce(ctx context.Context, apiKey, shopDomain string) error {
+	s, err := w.shopRepo.GetByDomain(ctx, apiKey, shopDomain)
+	if err != nil {
+		return err
+	}
+	if !s.State.HasToken() {
+		return nil
+	}
+
+	meta, err := w.shopify.FetchShop(ctx, s.ShopDomain, s.OfflineAccessToken)
+	if err != nil {
+		return err
+	}
+
+	if err := w.shopRepo.UpdateMetadata(ctx, apiKey, shopDomain, repository.ShopMetadata(*meta)); err != nil {
+		return err
+	}
+	w.log.Info("shop metadata synced", "api_key", apiKey, "shop", shopDomain)
+	return nil
+}
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_states
+  ADD COLUMN IF NOT EXISTS host TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- every migration from here on ends by recording its own version
+INSERT INTO schema_migrations (version)
+VALUES (1), (2), (3), (4), (5)
+ON CONFLICT (version) DO NOTHING;
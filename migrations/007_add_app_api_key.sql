@@ -0,0 +1,19 @@
+-- rows are owned by an app; '' marks rows written before multi-app support,
+-- the server assigns them to its default app on startup (db.AdoptLegacyRows)
+ALTER TABLE shops
+  ADD COLUMN IF NOT EXISTS api_key TEXT NOT NULL DEFAULT '';
+
+-- the same shop can install several apps of one deployment
+ALTER TABLE shops DROP CONSTRAINT IF EXISTS shops_shop_domain_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_shops_api_key_shop_domain ON shops (api_key, shop_domain);
+
+ALTER TABLE oauth_states
+  ADD COLUMN IF NOT EXISTS api_key TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE sessions
+  ADD COLUMN IF NOT EXISTS api_key TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_sessions_shop_domain;
+CREATE INDEX IF NOT EXISTS idx_sessions_api_key_shop_domain ON sessions (api_key, shop_domain);
+
+INSERT INTO schema_migrations (version) VALUES (7) ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,13 @@
+-- shop metadata synced from Shopify's shop object after install and on shop/update webhooks.
+-- metadata_synced_at stays NULL until the first successful sync
+ALTER TABLE shops
+  ADD COLUMN IF NOT EXISTS shop_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS contact_email TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS plan_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS currency_code TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS iana_timezone TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS primary_domain TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS metadata_synced_at TIMESTAMPTZ;
+
+INSERT INTO schema_migrations (version) VALUES (8) ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,18 @@
+-- explicit lifecycle instead of "row present or not" (repository.ShopState). Existing rows are installed
+ALTER TABLE shops
+  ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'active',
+  ADD COLUMN IF NOT EXISTS state_changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  ADD COLUMN IF NOT EXISTS first_installed_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS reinstall_count INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS uninstalled_at TIMESTAMPTZ;
+
+UPDATE shops SET first_installed_at = installed_at WHERE first_installed_at IS NULL;
+ALTER TABLE shops
+  ALTER COLUMN first_installed_at SET DEFAULT NOW(),
+  ALTER COLUMN first_installed_at SET NOT NULL;
+
+ALTER TABLE shops DROP CONSTRAINT IF EXISTS shops_state_check;
+ALTER TABLE shops ADD CONSTRAINT shops_state_check
+  CHECK (state IN ('installing', 'active', 'frozen', 'needs_reauth', 'uninstalled', 'redacted'));
+
+INSERT INTO schema_migrations (version) VALUES (9) ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,40 @@
+-- append-only history of every installation (repository.ShopEvent), written in the same transaction
+-- as the change to shops it describes
+CREATE TABLE IF NOT EXISTS shop_events (
+  id BIGSERIAL PRIMARY KEY,
+  api_key TEXT NOT NULL,
+  shop_domain TEXT NOT NULL,
+  type TEXT NOT NULL,
+  actor TEXT NOT NULL,
+  ip TEXT NOT NULL DEFAULT '',
+  metadata JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_shop_events_shop ON shop_events (api_key, shop_domain, id DESC);
+
+-- history is never rewritten: reject UPDATE and DELETE. The one exception is shop/redact anonymizing
+-- an event, an UPDATE that only blanks ip and metadata
+CREATE OR REPLACE FUNCTION shop_events_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE'
+     AND NEW.id = OLD.id
+     AND NEW.api_key = OLD.api_key
+     AND NEW.shop_domain = OLD.shop_domain
+     AND NEW.type = OLD.type
+     AND NEW.actor = OLD.actor
+     AND NEW.created_at = OLD.created_at
+     AND NEW.ip = ''
+     AND NEW.metadata = '{}'::jsonb THEN
+    RETURN NEW;
+  END IF;
+  RAISE EXCEPTION 'shop_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS shop_events_append_only ON shop_events;
+CREATE TRIGGER shop_events_append_only
+  BEFORE UPDATE OR DELETE ON shop_events
+  FOR EACH ROW EXECUTE FUNCTION shop_events_append_only();
+
+INSERT INTO schema_migrations (version) VALUES (10) ON CONFLICT (version) DO NOTHING;